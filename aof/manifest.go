@@ -0,0 +1,145 @@
+// Package aof reads the append only file: the manifest listing its files
+// and the command stream they hold.
+//
+// The AOF is made of several files kept in a directory: a base file holding
+// the dataset as of the last rewrite, either in RDB or in AOF format,
+// followed by the incremental files the write commands executed since then
+// are appended to. The manifest lists them, in the same format as Redis 7:
+//
+//	file appendonly.aof.1.base.rdb seq 1 type b
+//	file appendonly.aof.1.incr.aof seq 1 type i
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeBase    = "b"
+	TypeIncr    = "i"
+	TypeHistory = "h" // replaced by a rewrite, waiting to be deleted
+)
+
+type FileInfo struct {
+	Name string
+	Seq  int64
+	Type string
+}
+
+type Manifest struct {
+	Base *FileInfo
+	Incr []FileInfo
+}
+
+// Files returns the files to load, in order.
+func (m *Manifest) Files() []FileInfo {
+	var files []FileInfo
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+
+	return append(files, m.Incr...)
+}
+
+func (m *Manifest) NextIncrSeq() int64 {
+	if len(m.Incr) == 0 {
+		return 1
+	}
+
+	return m.Incr[len(m.Incr)-1].Seq + 1
+}
+
+func (m *Manifest) NextBaseSeq() int64 {
+	if m.Base == nil {
+		return 1
+	}
+
+	return m.Base.Seq + 1
+}
+
+func (m *Manifest) Clone() *Manifest {
+	c := &Manifest{Incr: append([]FileInfo(nil), m.Incr...)}
+	if m.Base != nil {
+		base := *m.Base
+		c.Base = &base
+	}
+
+	return c
+}
+
+func (m *Manifest) String() string {
+	var sb strings.Builder
+	for _, f := range m.Files() {
+		fmt.Fprintf(&sb, "file %s seq %d type %s\n", f.Name, f.Seq, f.Type)
+	}
+
+	return sb.String()
+}
+
+// ParseManifest reads a manifest. History files are skipped.
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("line %d: odd number of fields", line)
+		}
+
+		var info FileInfo
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.Name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil || seq < 1 {
+					return nil, fmt.Errorf("line %d: invalid seq %q", line, fields[i+1])
+				}
+				info.Seq = seq
+			case "type":
+				info.Type = fields[i+1]
+			}
+		}
+
+		if info.Name == "" || info.Seq == 0 {
+			return nil, fmt.Errorf("line %d: missing file name or seq", line)
+		}
+
+		switch info.Type {
+		case TypeBase:
+			if m.Base != nil {
+				return nil, errors.New("more than one base file")
+			}
+			m.Base = &info
+		case TypeIncr:
+			if len(m.Incr) > 0 && info.Seq <= m.Incr[len(m.Incr)-1].Seq {
+				return nil, fmt.Errorf("line %d: incr files out of order", line)
+			}
+			m.Incr = append(m.Incr, info)
+		case TypeHistory:
+		default:
+			return nil, fmt.Errorf("line %d: unknown file type %q", line, info.Type)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if m.Base == nil && len(m.Incr) == 0 {
+		return nil, errors.New("no base nor incr file")
+	}
+
+	return m, nil
+}
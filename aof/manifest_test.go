@@ -0,0 +1,116 @@
+package aof
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     *Manifest
+	}{
+		{
+			name:     "base and incr files",
+			manifest: "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\nfile appendonly.aof.2.incr.aof seq 2 type i\n",
+			want: &Manifest{
+				Base: &FileInfo{Name: "appendonly.aof.1.base.rdb", Seq: 1, Type: TypeBase},
+				Incr: []FileInfo{
+					{Name: "appendonly.aof.1.incr.aof", Seq: 1, Type: TypeIncr},
+					{Name: "appendonly.aof.2.incr.aof", Seq: 2, Type: TypeIncr},
+				},
+			},
+		},
+		{
+			name:     "incr file only",
+			manifest: "file appendonly.aof.1.incr.aof seq 1 type i\n",
+			want:     &Manifest{Incr: []FileInfo{{Name: "appendonly.aof.1.incr.aof", Seq: 1, Type: TypeIncr}}},
+		},
+		{
+			name:     "history files, comments and blank lines skipped",
+			manifest: "# written by redis\n\nfile appendonly.aof.1.base.rdb seq 1 type h\nfile appendonly.aof.2.base.rdb seq 2 type b\n",
+			want:     &Manifest{Base: &FileInfo{Name: "appendonly.aof.2.base.rdb", Seq: 2, Type: TypeBase}},
+		},
+		{
+			name:     "fields in any order",
+			manifest: "type b seq 3 file appendonly.aof.3.base.aof\n",
+			want:     &Manifest{Base: &FileInfo{Name: "appendonly.aof.3.base.aof", Seq: 3, Type: TypeBase}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseManifest(strings.NewReader(tt.manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Fatalf("got %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseManifestInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{name: "empty", manifest: ""},
+		{name: "history only", manifest: "file appendonly.aof.1.base.rdb seq 1 type h\n"},
+		{name: "odd number of fields", manifest: "file appendonly.aof.1.base.rdb seq 1 type\n"},
+		{name: "missing seq", manifest: "file appendonly.aof.1.base.rdb type b\n"},
+		{name: "missing file name", manifest: "seq 1 type b\n"},
+		{name: "invalid seq", manifest: "file appendonly.aof.1.base.rdb seq 0 type b\n"},
+		{name: "unknown type", manifest: "file appendonly.aof.1.base.rdb seq 1 type x\n"},
+		{
+			name:     "two base files",
+			manifest: "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.2.base.rdb seq 2 type b\n",
+		},
+		{
+			name:     "incr files out of order",
+			manifest: "file appendonly.aof.2.incr.aof seq 2 type i\nfile appendonly.aof.1.incr.aof seq 1 type i\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := ParseManifest(strings.NewReader(tt.manifest)); err == nil {
+				t.Fatalf("got %+v, want an error", m)
+			}
+		})
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := &Manifest{
+		Base: &FileInfo{Name: "appendonly.aof.4.base.rdb", Seq: 4, Type: TypeBase},
+		Incr: []FileInfo{
+			{Name: "appendonly.aof.7.incr.aof", Seq: 7, Type: TypeIncr},
+			{Name: "appendonly.aof.8.incr.aof", Seq: 8, Type: TypeIncr},
+		},
+	}
+
+	got, err := ParseManifest(strings.NewReader(m.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("got %+v back, want %+v", got, m)
+	}
+
+	if m.NextBaseSeq() != 5 || m.NextIncrSeq() != 9 {
+		t.Fatalf("got next seqs %d and %d, want 5 and 9", m.NextBaseSeq(), m.NextIncrSeq())
+	}
+
+	c := m.Clone()
+	c.Base.Seq = 5
+	c.Incr[0].Seq = 10
+	if m.Base.Seq != 4 || m.Incr[0].Seq != 7 {
+		t.Fatal("changing a clone changed the manifest")
+	}
+}
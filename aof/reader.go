@@ -0,0 +1,127 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Annotations are lines starting with '#' between the commands, ignored by
+// the loading. With aof-timestamp-enabled the server writes a "#TS:<unix
+// time>" one whenever the second changes, making point-in-time recovery
+// possible.
+
+const timestampPrefix = "#TS:"
+
+// TimestampAnnotation returns the annotation marking the commands that
+// follow as executed at unix time ts.
+func TimestampAnnotation(ts int64) string {
+	return timestampPrefix + strconv.FormatInt(ts, 10) + "\r\n"
+}
+
+// Entry is a command read from an AOF file.
+type Entry struct {
+	Args      []string
+	Offset    int64 // where the entry starts in the file, annotations included
+	Timestamp int64 // of the last timestamp annotation read, 0 when none
+}
+
+// Reader reads the commands of an AOF file, or of what follows the RDB
+// preamble of a base file.
+type Reader struct {
+	r   *bufio.Reader
+	off int64
+	ts  int64
+}
+
+// NewReader returns a reader of the commands of r, offset being the
+// position of r in the file.
+func NewReader(r *bufio.Reader, offset int64) *Reader {
+	return &Reader{r: r, off: offset}
+}
+
+// Offset returns the position in the file right after the last entry read.
+func (r *Reader) Offset() int64 {
+	return r.off
+}
+
+// Next reads the next command. It returns io.EOF at the end of the file
+// and io.ErrUnexpectedEOF when the file ends in the middle of a command,
+// as left by a crash during a write, Offset telling where the last complete
+// command ends.
+func (r *Reader) Next() (Entry, error) {
+	e := Entry{Offset: r.off}
+	off := r.off
+
+	for {
+		b, err := r.r.Peek(1)
+		if err == io.EOF {
+			return e, io.EOF
+		}
+		if err != nil {
+			return e, err
+		}
+
+		if b[0] != '#' {
+			break
+		}
+
+		line, n, err := resp.ReadUntilCRLF(r.r, 0)
+		if err == io.EOF {
+			return e, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return e, fmt.Errorf("bad annotation at offset %d: %w", off, err)
+		}
+		off += int64(n)
+
+		if strings.HasPrefix(string(line), timestampPrefix) {
+			if r.ts, err = strconv.ParseInt(string(line[len(timestampPrefix):]), 10, 64); err != nil {
+				return e, fmt.Errorf("bad timestamp annotation at offset %d", off-int64(n))
+			}
+		}
+	}
+
+	msg, n, err := resp.ParseMessage(r.r, resp.Limits{})
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return e, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return e, fmt.Errorf("bad command at offset %d: %w", off, err)
+	}
+
+	arr, ok := msg.Content.([]resp.Message)
+	if msg.Type != resp.TypeArray || !ok || len(arr) == 0 {
+		return e, fmt.Errorf("bad command at offset %d: expected an array of bulk strings", off)
+	}
+
+	for _, item := range arr {
+		arg, ok := item.Content.(string)
+		if item.Type != resp.TypeBulkString || !ok {
+			return e, fmt.Errorf("bad command at offset %d: expected an array of bulk strings", off)
+		}
+		e.Args = append(e.Args, arg)
+	}
+
+	r.off = off + int64(n)
+	e.Timestamp = r.ts
+	return e, nil
+}
+
+// Point is where a point-in-time recovery stops. Zero fields don't limit
+// it.
+type Point struct {
+	Timestamp int64 // unix time of the last commands replayed
+	Commands  int64 // number of commands replayed
+}
+
+// Reached reports whether e, the n-th command of the AOF counting from 1,
+// is past p and must not be replayed.
+func (p Point) Reached(e Entry, n int64) bool {
+	return (p.Timestamp > 0 && e.Timestamp > p.Timestamp) || (p.Commands > 0 && n > p.Commands)
+}
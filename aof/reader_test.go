@@ -0,0 +1,176 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// cmd encodes a command the way the server appends it.
+func cmd(args ...string) string {
+	return resp.EncodeBulkStrings(args...)
+}
+
+// readAll reads the entries of file until the first error, returned unless
+// it is io.EOF, along with the offset reached.
+func readAll(file string) ([]Entry, int64, error) {
+	r := NewReader(bufio.NewReader(strings.NewReader(file)), 0)
+
+	var entries []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, r.Offset(), nil
+		}
+		if err != nil {
+			return entries, r.Offset(), err
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+func TestReader(t *testing.T) {
+	set := cmd("SET", "k", "v")
+
+	tests := []struct {
+		name    string
+		file    string
+		entries []Entry
+		offset  int64
+	}{
+		{name: "empty", file: ""},
+		{
+			name: "commands",
+			file: set + cmd("SET", "a", "b", "PXAT", "1700000000000"),
+			entries: []Entry{
+				{Args: []string{"SET", "k", "v"}},
+				{Args: []string{"SET", "a", "b", "PXAT", "1700000000000"}, Offset: int64(len(set))},
+			},
+			offset: int64(len(set + cmd("SET", "a", "b", "PXAT", "1700000000000"))),
+		},
+		{
+			name: "timestamps",
+			file: TimestampAnnotation(1700000000) + set + set + TimestampAnnotation(1700000005) + set,
+			entries: []Entry{
+				{Args: []string{"SET", "k", "v"}, Timestamp: 1700000000},
+				{Args: []string{"SET", "k", "v"}, Offset: int64(len(TimestampAnnotation(1700000000) + set)), Timestamp: 1700000000},
+				{Args: []string{"SET", "k", "v"}, Offset: int64(len(TimestampAnnotation(1700000000) + set + set)), Timestamp: 1700000005},
+			},
+			offset: int64(len(TimestampAnnotation(1700000000) + set + set + TimestampAnnotation(1700000005) + set)),
+		},
+		{
+			name:    "other annotations",
+			file:    "#comment\r\n" + set,
+			entries: []Entry{{Args: []string{"SET", "k", "v"}}},
+			offset:  int64(len("#comment\r\n" + set)),
+		},
+		{
+			name:   "trailing annotation",
+			file:   TimestampAnnotation(1700000000),
+			offset: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, offset, err := readAll(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(entries, tt.entries) {
+				t.Fatalf("got %+v, want %+v", entries, tt.entries)
+			}
+
+			if offset != tt.offset {
+				t.Fatalf("got offset %d, want %d", offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	set := cmd("SET", "k", "v")
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "cut in a command", file: set + set[:10]},
+		{name: "cut in a bulk string", file: set + "*3\r\n$3\r\nSET\r\n$1\r\nk"},
+		{name: "cut in an annotation", file: set + "#TS:17000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, offset, err := readAll(tt.file)
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("got error %v, want io.ErrUnexpectedEOF", err)
+			}
+
+			if len(entries) != 1 || offset != int64(len(set)) {
+				t.Fatalf("got %d entries up to offset %d, want 1 up to %d", len(entries), offset, len(set))
+			}
+		})
+	}
+}
+
+func TestReaderCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "bad timestamp", file: "#TS:yesterday\r\n" + cmd("SET", "k", "v")},
+		{name: "annotation without CR", file: "#TS:1700000000\n" + cmd("SET", "k", "v")},
+		{name: "not an array", file: "+OK\r\n"},
+		{name: "empty array", file: "*0\r\n"},
+		{name: "nested array", file: "*1\r\n*1\r\n$1\r\na\r\n"},
+		{name: "integer argument", file: "*2\r\n$3\r\nDEL\r\n:1\r\n"},
+		{name: "null argument", file: "*2\r\n$3\r\nDEL\r\n$-1\r\n"},
+		{name: "garbage", file: "SET k v\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readAll(tt.file)
+			if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("got error %v, want a corruption error", err)
+			}
+		})
+	}
+}
+
+func TestPointReached(t *testing.T) {
+	e := Entry{Timestamp: 1700000010}
+
+	tests := []struct {
+		name    string
+		point   Point
+		entry   Entry
+		n       int64
+		reached bool
+	}{
+		{name: "no limit", entry: e, n: 100},
+		{name: "before the timestamp", point: Point{Timestamp: 1700000020}, entry: e, n: 1},
+		{name: "at the timestamp", point: Point{Timestamp: 1700000010}, entry: e, n: 1},
+		{name: "past the timestamp", point: Point{Timestamp: 1700000009}, entry: e, n: 1, reached: true},
+		{name: "without timestamp", point: Point{Timestamp: 1700000009}, entry: Entry{}, n: 1},
+		{name: "at the command", point: Point{Commands: 3}, entry: e, n: 3},
+		{name: "past the command", point: Point{Commands: 3}, entry: e, n: 4, reached: true},
+		{name: "first limit reached", point: Point{Timestamp: 1700000020, Commands: 3}, entry: e, n: 4, reached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.point.Reached(tt.entry, tt.n); got != tt.reached {
+				t.Fatalf("got %v, want %v", got, tt.reached)
+			}
+		})
+	}
+}
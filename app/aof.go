@@ -0,0 +1,681 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// The append only file logs every write command in the RESP form it is
+// propagated to replicas in, to the last incr file of the manifest. A
+// rewrite replaces the files with a new base, an RDB snapshot of the
+// dataset, while the writes keep going to a new incr file.
+
+var errAOFRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+func validateAppendFsync(v string) error {
+	switch v {
+	case "always", "everysec", "no":
+		return nil
+	}
+
+	return errors.New("argument must be one of always, everysec or no")
+}
+
+func applyAppendOnly(s *Server, value string) error {
+	if on, _ := parseBool(value); on {
+		if err := s.startAppendOnly(false); err != nil {
+			return err
+		}
+
+		s.createBacklogForAOF()
+		return nil
+	}
+
+	return s.stopAppendOnly()
+}
+
+// startAppendOnly enables the AOF by rewriting it from the current dataset,
+// waiting for the base file to be written when wait is set. It is a no-op
+// when the AOF is already enabled.
+func (s *Server) startAppendOnly(wait bool) error {
+	s.aofMu.Lock()
+	enabled := s.aofFile != nil
+	s.aofMu.Unlock()
+
+	if enabled {
+		return nil
+	}
+
+	done, err := s.rewriteAppendOnly()
+	if err != nil {
+		return err
+	}
+
+	if wait {
+		return <-done
+	}
+
+	return nil
+}
+
+// createBacklogForAOF creates the backlog once the AOF is enabled, for the
+// replication offset WAITAOF relies on to advance without replicas too.
+func (s *Server) createBacklogForAOF() {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	s.ensureBacklog()
+}
+
+// stopAppendOnly syncs and closes the AOF.
+func (s *Server) stopAppendOnly() error {
+	s.aofMu.Lock()
+	defer s.aofMu.Unlock()
+
+	if s.aofFile == nil {
+		return nil
+	}
+
+	err := s.closeAppendOnlyFile()
+	log.Println("AOF disabled")
+	return err
+}
+
+// closeAppendOnlyFile syncs and closes the incr file written to. It must be
+// called with aofMu held.
+func (s *Server) closeAppendOnlyFile() error {
+	err := s.aofFile.Sync()
+	if cerr := s.aofFile.Close(); err == nil {
+		err = cerr
+	}
+	s.aofFile = nil
+
+	return err
+}
+
+// createIncrFile creates the next incr file of m. It must be called with
+// aofMu held.
+func (s *Server) createIncrFile(m *aof.Manifest) (*os.File, aof.FileInfo, error) {
+	seq := m.NextIncrSeq()
+	info := aof.FileInfo{Name: s.aofIncrName(seq), Seq: seq, Type: aof.TypeIncr}
+
+	f, err := os.OpenFile(filepath.Join(s.aofDir(), info.Name), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0o644)
+	return f, info, err
+}
+
+// openAppendOnlyFile starts appending to the last incr file of the loaded
+// manifest m, creating one if it has none.
+func (s *Server) openAppendOnlyFile(m *aof.Manifest) error {
+	s.aofMu.Lock()
+	defer s.aofMu.Unlock()
+
+	if len(m.Incr) == 0 {
+		f, info, err := s.createIncrFile(m)
+		if err != nil {
+			return err
+		}
+
+		next := m.Clone()
+		next.Incr = append(next.Incr, info)
+		if err := s.persistAOFManifest(next); err != nil {
+			f.Close()
+			return err
+		}
+
+		s.aofManifest = next
+		s.aofFile = f
+	} else {
+		last := m.Incr[len(m.Incr)-1]
+		f, err := os.OpenFile(filepath.Join(s.aofDir(), last.Name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+
+		s.aofManifest = m
+		s.aofFile = f
+	}
+
+	s.aofUnsynced = false
+	s.aofLastTimestamp = 0
+	atomic.StoreInt32(&s.aofLastWriteFailed, 0)
+	return nil
+}
+
+// feedAppendOnlyFile appends a write command to the AOF, syncing it to disk
+// right away when appendfsync is always.
+func (s *Server) feedAppendOnlyFile(c command) {
+	s.aofMu.Lock()
+	defer s.aofMu.Unlock()
+
+	if s.aofFile == nil {
+		return
+	}
+
+	buf := c.encode()
+	if s.configBool("aof-timestamp-enabled") {
+		if now := time.Now().Unix(); now != s.aofLastTimestamp {
+			buf = aof.TimestampAnnotation(now) + buf
+			s.aofLastTimestamp = now
+		}
+	}
+
+	n, err := s.aofFile.WriteString(buf)
+	s.aofCurrentSize += int64(n)
+	if err != nil {
+		atomic.StoreInt32(&s.aofLastWriteFailed, 1)
+		log.Println("Error writing to the AOF file:", err)
+		return
+	}
+
+	if s.getConfig("appendfsync") != "always" {
+		s.aofUnsynced = true
+		return
+	}
+
+	if err := s.aofFile.Sync(); err != nil {
+		atomic.StoreInt32(&s.aofLastWriteFailed, 1)
+		log.Println("Can't persist AOF for fsync error when the AOF fsync policy is 'always':", err)
+		return
+	}
+
+	atomic.StoreInt32(&s.aofLastWriteFailed, 0)
+}
+
+// runAppendOnlyCron syncs the AOF every second when appendfsync is
+// everysec and starts the automatic rewrites, until ctx is done.
+func (s *Server) runAppendOnlyCron(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s.getConfig("appendfsync") == "everysec" {
+			s.syncAppendOnlyFile()
+		}
+
+		if growth, ok := s.aofGrowthOverLimit(); ok {
+			log.Printf("Starting automatic rewriting of AOF on %d%% growth", growth)
+			if _, err := s.rewriteAppendOnly(); err != nil && !errors.Is(err, errAOFRewriteInProgress) {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// syncAppendOnlyFile fsyncs the AOF, then records the replication offset
+// it is fsynced up to for WAITAOF.
+func (s *Server) syncAppendOnlyFile() {
+	// every write propagated by now was written to the AOF before
+	s.ReplicasMapMux.Lock()
+	offset := s.ReplicationOffset
+	s.ReplicasMapMux.Unlock()
+
+	s.aofMu.Lock()
+	if s.aofFile == nil {
+		s.aofMu.Unlock()
+		return
+	}
+
+	if s.aofUnsynced {
+		if err := s.aofFile.Sync(); err != nil {
+			atomic.StoreInt32(&s.aofLastWriteFailed, 1)
+			log.Println("Error syncing the AOF file:", err)
+			s.aofMu.Unlock()
+			return
+		}
+
+		s.aofUnsynced = false
+		atomic.StoreInt32(&s.aofLastWriteFailed, 0)
+	}
+	s.aofMu.Unlock()
+
+	if atomic.LoadInt64(&s.aofFsyncedOffset) < int64(offset) {
+		atomic.StoreInt64(&s.aofFsyncedOffset, int64(offset))
+
+		s.ReplicasMapMux.Lock()
+		s.notifyAcks()
+		s.ReplicasMapMux.Unlock()
+	}
+}
+
+// aofGrowthOverLimit returns how much the AOF grew since the last rewrite,
+// in percent, and whether it calls for an automatic rewrite according to
+// auto-aof-rewrite-percentage and auto-aof-rewrite-min-size.
+func (s *Server) aofGrowthOverLimit() (int64, bool) {
+	perc := s.configInt("auto-aof-rewrite-percentage")
+	if perc == 0 || atomic.LoadInt32(&s.aofRewriteInProgress) == 1 {
+		return 0, false
+	}
+
+	s.aofMu.Lock()
+	enabled, current, base := s.aofFile != nil, s.aofCurrentSize, s.aofBaseSize
+	s.aofMu.Unlock()
+
+	if !enabled || current < s.configInt("auto-aof-rewrite-min-size") {
+		return 0, false
+	}
+
+	if base == 0 {
+		base = 1
+	}
+
+	growth := current*100/base - 100
+	return growth, growth >= perc
+}
+
+// aofRewrite is a rewrite in progress: Snapshot is written as Base, the
+// writes executed since it was taken going to Incr, if the AOF is enabled.
+type aofRewrite struct {
+	Snapshot rdb.RDB
+	Base     aof.FileInfo
+	Incr     *aof.FileInfo
+}
+
+// rewriteAppendOnly starts rewriting the AOF in the background. The
+// returned channel receives its outcome.
+func (s *Server) rewriteAppendOnly() (<-chan error, error) {
+	if !atomic.CompareAndSwapInt32(&s.aofRewriteInProgress, 0, 1) {
+		return nil, errAOFRewriteInProgress
+	}
+
+	rw, err := s.startAOFRewrite()
+	if err != nil {
+		atomic.StoreInt32(&s.aofRewriteInProgress, 0)
+		atomic.StoreInt32(&s.aofLastRewriteFailed, 1)
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	log.Println("Background append only file rewriting started")
+	go func() {
+		defer atomic.StoreInt32(&s.aofRewriteInProgress, 0)
+
+		err := s.finishAOFRewrite(rw)
+		if err != nil {
+			atomic.StoreInt32(&s.aofLastRewriteFailed, 1)
+			log.Println("Background AOF rewrite error:", err)
+		} else {
+			atomic.StoreInt32(&s.aofLastRewriteFailed, 0)
+			log.Println("Background AOF rewrite finished successfully")
+		}
+		done <- err
+	}()
+
+	return done, nil
+}
+
+// startAOFRewrite switches the writes to a new incr file and takes the
+// snapshot of the dataset the new base is made of. Write commands are held
+// off meanwhile so every write is either in the snapshot or in the new incr
+// file. While the AOF is being enabled the new incr file is only added to
+// the manifest with the base, the dataset being nowhere else on disk.
+func (s *Server) startAOFRewrite() (*aofRewrite, error) {
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return nil, err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.aofMu.Lock()
+	defer s.aofMu.Unlock()
+
+	if s.aofManifest == nil {
+		// continue the numbering of the files left by a previous run
+		m, err := s.loadAOFManifest()
+		if err != nil || m == nil {
+			m = &aof.Manifest{}
+		}
+		s.aofManifest = m
+	}
+
+	m := s.aofManifest
+	rw := &aofRewrite{
+		Base: aof.FileInfo{Name: s.aofBaseName(m.NextBaseSeq()), Seq: m.NextBaseSeq(), Type: aof.TypeBase},
+	}
+
+	if s.configBool("appendonly") {
+		f, info, err := s.createIncrFile(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.aofFile != nil {
+			next := m.Clone()
+			next.Incr = append(next.Incr, info)
+			if err := s.persistAOFManifest(next); err != nil {
+				f.Close()
+				os.Remove(f.Name())
+				return nil, err
+			}
+			s.aofManifest = next
+
+			if err := s.closeAppendOnlyFile(); err != nil {
+				log.Println("Error closing the AOF file:", err)
+			}
+		}
+
+		s.aofFile = f
+		s.aofUnsynced = false
+		s.aofLastTimestamp = 0
+		rw.Incr = &info
+	}
+
+	rw.Snapshot = s.snapshotLocked()
+	rw.Snapshot.AuxField[rdb.AuxFieldAOFBase] = "1"
+
+	return rw, nil
+}
+
+// finishAOFRewrite writes the new base then replaces the manifest, removing
+// the files it no longer lists.
+func (s *Server) finishAOFRewrite(rw *aofRewrite) error {
+	dir := s.aofDir()
+	err := s.writeRDBFile(filepath.Join(dir, rw.Base.Name), rw.Snapshot)
+
+	s.aofMu.Lock()
+	defer s.aofMu.Unlock()
+
+	if err != nil {
+		s.abortAOFRewrite(rw)
+		return err
+	}
+
+	next := &aof.Manifest{Base: &rw.Base}
+	if rw.Incr != nil {
+		next.Incr = []aof.FileInfo{*rw.Incr}
+	}
+
+	if err := s.persistAOFManifest(next); err != nil {
+		s.abortAOFRewrite(rw)
+		os.Remove(filepath.Join(dir, rw.Base.Name))
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, f := range next.Files() {
+		keep[f.Name] = true
+	}
+
+	for _, f := range s.aofManifest.Files() {
+		if !keep[f.Name] {
+			if err := os.Remove(filepath.Join(dir, f.Name)); err != nil && !os.IsNotExist(err) {
+				log.Println("Error removing the old AOF file:", err)
+			}
+		}
+	}
+
+	s.aofManifest = next
+	s.aofBaseSize = aofFileSize(dir, next)
+	s.aofCurrentSize = s.aofBaseSize
+
+	return nil
+}
+
+// abortAOFRewrite gives up on a failed rewrite. The writes to the new incr
+// file are kept when the manifest already lists it, otherwise the AOF was
+// being enabled and is disabled again. It must be called with aofMu held.
+func (s *Server) abortAOFRewrite(rw *aofRewrite) {
+	if rw.Incr == nil {
+		return
+	}
+
+	for _, f := range s.aofManifest.Incr {
+		if f.Seq == rw.Incr.Seq {
+			return
+		}
+	}
+
+	if s.aofFile != nil {
+		s.closeAppendOnlyFile()
+	}
+	os.Remove(filepath.Join(s.aofDir(), rw.Incr.Name))
+	s.setConfig("appendonly", "no")
+	log.Println("AOF disabled, the append only file couldn't be created")
+}
+
+// aofFileSize returns the total size of the files of m.
+func aofFileSize(dir string, m *aof.Manifest) int64 {
+	var size int64
+	for _, f := range m.Files() {
+		if info, err := os.Stat(filepath.Join(dir, f.Name)); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// upgradeAppendOnlyFile moves an AOF made of a single file, as written by
+// older versions, into appenddirname as the base file of a new manifest. It
+// returns nil when there is no such file.
+func (s *Server) upgradeAppendOnlyFile() (*aof.Manifest, error) {
+	name := s.getConfig("appendfilename")
+	legacy := filepath.Join(s.getConfig("dir"), name)
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(legacy, filepath.Join(s.aofDir(), name)); err != nil {
+		return nil, err
+	}
+
+	m := &aof.Manifest{Base: &aof.FileInfo{Name: name, Seq: 1, Type: aof.TypeBase}}
+	if err := s.persistAOFManifest(m); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Successfully migrated an old-style AOF %s into the AOF directory", legacy)
+	return m, nil
+}
+
+// LoadAOF loads the dataset from the files of m, in order. A non nil until
+// makes it a point-in-time recovery: loading stops there and the files are
+// left untouched.
+func (s *Server) LoadAOF(m *aof.Manifest, until *aof.Point) error {
+	start := time.Now()
+	if m.Base == nil {
+		s.setDataset(rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}})
+	}
+
+	var replayed int64
+	files := m.Files()
+	for i, f := range files {
+		path := filepath.Join(s.aofDir(), f.Name)
+		reached, err := s.loadAppendOnlyFile(path, f.Type == aof.TypeBase, i == len(files)-1, until, &replayed)
+		if err != nil {
+			return err
+		}
+
+		if reached {
+			log.Printf("Stopped loading the AOF at %s after %d commands", f.Name, replayed)
+			break
+		}
+	}
+
+	size := aofFileSize(s.aofDir(), m)
+	s.aofMu.Lock()
+	s.aofCurrentSize, s.aofBaseSize = size, size
+	s.aofMu.Unlock()
+
+	atomic.StoreInt64(&s.Dirty, 0)
+	log.Printf("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+
+	return nil
+}
+
+// loadAppendOnlyFile loads an AOF file: its RDB preamble, if any, then the
+// commands following it, counted in replayed. A base file replaces the
+// dataset, the others are applied on top of it. A command cut short at the
+// end of the last file, as left by a crash in the middle of a write, is
+// removed when aof-load-truncated is enabled. It reports whether it stopped
+// before the end of the file because until was reached.
+func (s *Server) loadAppendOnlyFile(path string, base, last bool, until *aof.Point, replayed *int64) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r, err := s.loadingReader(file)
+	if err != nil {
+		return false, err
+	}
+
+	if base {
+		data := rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}}
+		if magic, _ := r.Peek(5); string(magic) == "REDIS" {
+			log.Println("Reading RDB base file on AOF loading...")
+			if data, err = rdb.ParseFile(r, s.rdbOptions()); err != nil {
+				return false, fmt.Errorf("bad RDB base file %s: %w", path, err)
+			}
+		}
+
+		// the replication ID and offset of the base aren't restored, the
+		// commands replayed after it go past that offset uncounted
+		s.setDataset(data)
+	}
+
+	ar := aof.NewReader(r, atomic.LoadInt64(&s.loadingLoaded)-int64(r.Buffered()))
+	fake := newFakeClient(s)
+	var commands int
+	for {
+		e, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF && until != nil {
+			log.Printf("Ignoring the incomplete command at the end of %s", path)
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			if !last || !s.configBool("aof-load-truncated") {
+				return false, fmt.Errorf("unexpected end of file reading the append only file %s at offset %d, "+
+					"set aof-load-truncated to yes to load it anyway", path, ar.Offset())
+			}
+
+			log.Printf("!!! Warning: short read while loading the AOF file %s !!!", path)
+			log.Printf("!!! Truncating the AOF at offset %d !!!", ar.Offset())
+			if err := os.Truncate(path, ar.Offset()); err != nil {
+				return false, fmt.Errorf("error truncating the AOF file: %w", err)
+			}
+			log.Println("AOF loaded anyway because aof-load-truncated is enabled")
+			break
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("bad file format reading the append only file %s: %w", path, err)
+		}
+
+		if until != nil && until.Reached(e, *replayed+1) {
+			return true, nil
+		}
+
+		cmd := command{cmd: e.Args[0], args: e.Args[1:]}
+		if _, ok := lookupCommand(cmd.cmd); !ok {
+			return false, fmt.Errorf("unknown command '%s' reading the append only file %s at offset %d", cmd.cmd, path, e.Offset)
+		}
+
+		s.execCommand(fake, cmd)
+		commands++
+		*replayed++
+	}
+
+	log.Printf("Done loading %d commands from %s", commands, filepath.Base(path))
+	return false, nil
+}
+
+// RecoverAOF replays the AOF up to until and saves the dataset it results
+// in to output, dir/dbfilename when empty.
+func (s *Server) RecoverAOF(until aof.Point, output string) error {
+	m, err := s.loadAOFManifest()
+	if err != nil {
+		return err
+	}
+
+	if m == nil {
+		return fmt.Errorf("no AOF manifest found at %s", s.aofManifestPath())
+	}
+
+	if err := s.LoadAOF(m, &until); err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = s.rdbPath()
+	}
+
+	if err := s.writeRDBFile(output, s.snapshot()); err != nil {
+		return err
+	}
+
+	log.Printf("Point-in-time recovery saved to %s", output)
+	return nil
+}
+
+func (s *Server) onBgrewriteaof(c *Client, args []string) string {
+	if _, err := s.rewriteAppendOnly(); err != nil {
+		return resp.EncodeError("ERR " + err.Error())
+	}
+
+	return "+Background append only file rewriting started\r\n"
+}
+
+func (s *Server) infoAppendOnly() []string {
+	s.aofMu.Lock()
+	enabled := 0
+	if s.aofFile != nil {
+		enabled = 1
+	}
+	current, base := s.aofCurrentSize, s.aofBaseSize
+	s.aofMu.Unlock()
+
+	writeStatus := "ok"
+	if atomic.LoadInt32(&s.aofLastWriteFailed) == 1 {
+		writeStatus = "err"
+	}
+
+	rewriteStatus := "ok"
+	if atomic.LoadInt32(&s.aofLastRewriteFailed) == 1 {
+		rewriteStatus = "err"
+	}
+
+	lines := []string{
+		fmt.Sprintf("aof_enabled:%d", enabled),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", atomic.LoadInt32(&s.aofRewriteInProgress)),
+		"aof_rewrite_scheduled:0",
+		fmt.Sprintf("aof_last_bgrewrite_status:%s", rewriteStatus),
+		fmt.Sprintf("aof_last_write_status:%s", writeStatus),
+	}
+
+	if enabled == 1 {
+		lines = append(lines,
+			fmt.Sprintf("aof_current_size:%d", current),
+			fmt.Sprintf("aof_base_size:%d", base),
+		)
+	}
+
+	return lines
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+)
+
+func (s *Server) aofDir() string {
+	return filepath.Join(s.getConfig("dir"), s.getConfig("appenddirname"))
+}
+
+func (s *Server) aofManifestPath() string {
+	return filepath.Join(s.aofDir(), s.getConfig("appendfilename")+".manifest")
+}
+
+func (s *Server) aofBaseName(seq int64) string {
+	return fmt.Sprintf("%s.%d.base.rdb", s.getConfig("appendfilename"), seq)
+}
+
+func (s *Server) aofIncrName(seq int64) string {
+	return fmt.Sprintf("%s.%d.incr.aof", s.getConfig("appendfilename"), seq)
+}
+
+// loadAOFManifest reads the manifest, returning nil when there is none.
+func (s *Server) loadAOFManifest() (*aof.Manifest, error) {
+	f, err := os.Open(s.aofManifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := aof.ParseManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AOF manifest %s: %w", s.aofManifestPath(), err)
+	}
+
+	return m, nil
+}
+
+// persistAOFManifest replaces the manifest with m atomically.
+func (s *Server) persistAOFManifest(m *aof.Manifest) error {
+	dir := s.aofDir()
+	tmp, err := os.CreateTemp(dir, "temp-*.manifest")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.WriteString(m.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.aofManifestPath()); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir makes the files renamed into dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
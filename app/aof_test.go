@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// newTestServer returns a server keeping its files in a temporary dir.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	s := &Server{
+		Config:           defaultConfig(),
+		ReplicationID:    newReplicationID(),
+		ReplicationID2:   emptyReplicationID,
+		SecondReplOffset: -1,
+	}
+	s.Config["dir"] = t.TempDir()
+
+	return s
+}
+
+// writeTestAOF writes an AOF made of a base holding "base" and an incr file
+// of four commands, two logged at 1700000000 and two at 1700000100.
+func writeTestAOF(t *testing.T, s *Server) {
+	t.Helper()
+
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}}
+	base.Database(0).Set("base", "0")
+
+	f, err := os.Create(filepath.Join(s.aofDir(), s.aofBaseName(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	if err := rdb.Write(w, rdb.Options{Checksum: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	incr := aof.TimestampAnnotation(1700000000) +
+		resp.EncodeBulkStrings("SET", "a", "1") +
+		resp.EncodeBulkStrings("SET", "b", "2") +
+		aof.TimestampAnnotation(1700000100) +
+		resp.EncodeBulkStrings("SET", "a", "3", "PXAT", "32503680000000") +
+		resp.EncodeBulkStrings("SET", "c", "4")
+	if err := os.WriteFile(filepath.Join(s.aofDir(), s.aofIncrName(1)), []byte(incr), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &aof.Manifest{
+		Base: &aof.FileInfo{Name: s.aofBaseName(1), Seq: 1, Type: aof.TypeBase},
+		Incr: []aof.FileInfo{{Name: s.aofIncrName(1), Seq: 1, Type: aof.TypeIncr}},
+	}
+	if err := os.WriteFile(s.aofManifestPath(), []byte(m.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverAOF(t *testing.T) {
+	tests := []struct {
+		name  string
+		until aof.Point
+		want  map[string]string
+	}{
+		{
+			name:  "whole file",
+			until: aof.Point{},
+			want:  map[string]string{"base": "0", "a": "3", "b": "2", "c": "4"},
+		},
+		{
+			name:  "up to a command",
+			until: aof.Point{Commands: 3},
+			want:  map[string]string{"base": "0", "a": "3", "b": "2"},
+		},
+		{
+			name:  "up to a timestamp",
+			until: aof.Point{Timestamp: 1700000050},
+			want:  map[string]string{"base": "0", "a": "1", "b": "2"},
+		},
+		{
+			name:  "before the first command",
+			until: aof.Point{Timestamp: 1600000000},
+			want:  map[string]string{"base": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			writeTestAOF(t, s)
+
+			output := filepath.Join(s.getConfig("dir"), "recovered.rdb")
+			if err := s.RecoverAOF(tt.until, output); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			data, err := rdb.ParseFile(bufio.NewReader(f), rdb.Options{Checksum: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			for key, field := range data.Database(0).Fields {
+				got[key] = string(field.Value.(rdb.StringValue))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverAOFWithoutManifest(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.RecoverAOF(aof.Point{Commands: 1}, ""); err == nil {
+		t.Fatal("got no error, want one for the missing manifest")
+	}
+}
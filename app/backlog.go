@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// minBacklogSize is the smallest backlog allocated whatever
+// repl-backlog-size says, as in redis.
+const minBacklogSize = 16 * 1024
+
+// replBacklog keeps the last bytes of the replication stream in a circular
+// buffer, so a replica that lost its link can continue from where it was
+// instead of being sent the whole dataset again.
+type replBacklog struct {
+	buf     []byte
+	idx     int // where the next byte goes in buf
+	histlen int // bytes of buf in use
+	end     int // replication offset of the next byte written
+}
+
+// newReplBacklog returns an empty backlog continuing the stream at offset,
+// the offset of the last byte written so far.
+func newReplBacklog(size int64, offset int) *replBacklog {
+	if size < minBacklogSize {
+		size = minBacklogSize
+	}
+
+	return &replBacklog{buf: make([]byte, size), end: offset + 1}
+}
+
+// firstByteOffset returns the replication offset of the oldest byte held.
+func (b *replBacklog) firstByteOffset() int {
+	return b.end - b.histlen
+}
+
+func (b *replBacklog) write(data string) {
+	b.end += len(data)
+	if len(data) > len(b.buf) {
+		data = data[len(data)-len(b.buf):]
+	}
+
+	for len(data) > 0 {
+		n := copy(b.buf[b.idx:], data)
+		data = data[n:]
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen += n
+	}
+
+	if b.histlen > len(b.buf) {
+		b.histlen = len(b.buf)
+	}
+}
+
+// since returns the stream from offset on, false when the backlog doesn't
+// hold it anymore or offset is past its end.
+func (b *replBacklog) since(offset int) (string, bool) {
+	if offset < b.firstByteOffset() || offset > b.end {
+		return "", false
+	}
+
+	n := b.end - offset
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		return string(b.buf[start : start+n]), true
+	}
+
+	return string(b.buf[start:]) + string(b.buf[:start+n-len(b.buf)]), true
+}
+
+// resize reallocates the buffer, keeping as much of the stream as fits.
+func (b *replBacklog) resize(size int64) {
+	if size < minBacklogSize {
+		size = minBacklogSize
+	}
+
+	data, _ := b.since(b.firstByteOffset())
+	resized := &replBacklog{buf: make([]byte, size), end: b.firstByteOffset()}
+	resized.write(data)
+	*b = *resized
+}
+
+func applyBacklogSize(s *Server, value string) error {
+	size, _ := parseMemory(value)
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if s.backlog != nil {
+		s.backlog.resize(size)
+	}
+
+	return nil
+}
+
+// newReplicationID returns a random replication ID, 40 hex characters.
+func newReplicationID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type clientClass int
+
+const (
+	clientClassNormal clientClass = iota
+	clientClassReplica
+	clientClassPubSub
+	clientClassMaster // our own link to the master, never limited
+)
+
+func (c clientClass) String() string {
+	switch c {
+	case clientClassReplica:
+		return "replica"
+	case clientClassPubSub:
+		return "pubsub"
+	case clientClassMaster:
+		return "master"
+	}
+
+	return "normal"
+}
+
+var errClientClosed = errors.New("client closed")
+
+// outputChunk is data queued for a client, limited unless it isn't
+// accounted for by the output buffer limits. A chunk with a src is
+// streamed from it instead, see writeFrom.
+type outputChunk struct {
+	data    string
+	limited bool
+	src     io.Reader
+}
+
+// discard closes the source of the chunks dropped without being sent.
+func discard(chunks []outputChunk) {
+	for _, chunk := range chunks {
+		if closer, ok := chunk.src.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Client is a connection to the server. Replies are queued in an output
+// buffer drained by a dedicated goroutine, so a peer that doesn't read
+// never blocks the goroutine producing data for it. A client whose buffer
+// grows past the limits of its class is disconnected.
+type Client struct {
+	Conn  net.Conn
+	Class clientClass
+
+	server *Server
+
+	// rewritten replaces the command being executed once propagated, see
+	// rewriteCommand.
+	rewritten *command
+
+	// woff is the replication offset reached by the last write of the
+	// client, that WAIT and WAITAOF wait for.
+	woff int
+
+	// replCapaEOF is set for a replica that can be streamed its dataset
+	// with an EOF mark, see repl-diskless-sync.
+	replCapaEOF bool
+
+	mu             sync.Mutex
+	cond           *sync.Cond
+	pending        []outputChunk
+	pendingBytes   int64
+	softLimitSince time.Time
+	closing        bool // flush what's pending then close
+	killed         bool // close without flushing
+}
+
+func newClient(s *Server, conn net.Conn) *Client {
+	c := &Client{
+		Conn:   conn,
+		server: s,
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	go c.writeLoop()
+
+	return c
+}
+
+// newFakeClient returns a client without connection whose replies are
+// discarded, used to run the commands read from the AOF.
+func newFakeClient(s *Server) *Client {
+	return &Client{server: s}
+}
+
+// rewriteCommand replaces the command being executed, as written to the AOF
+// and sent to replicas, with a deterministic equivalent giving the same
+// result when replayed later or elsewhere: a relative TTL turned into an
+// absolute one, a random pick into the removal of the member picked, as
+// SPOP is propagated as SREM.
+func (c *Client) rewriteCommand(args ...string) {
+	c.rewritten = &command{cmd: args[0], args: args[1:]}
+}
+
+func (c *Client) isFake() bool {
+	return c.Conn == nil
+}
+
+// SetClass changes which output buffer limits apply to the client.
+func (c *Client) SetClass(class clientClass) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Class = class
+}
+
+// Write queues data to be sent to the client.
+func (c *Client) Write(data string) error {
+	return c.write(data, true)
+}
+
+// writeUnlimited queues data not accounted for by the output buffer limits:
+// the RDB payload of a full resynchronization, that the limits of replicas
+// aren't meant for.
+func (c *Client) writeUnlimited(data string) error {
+	return c.write(data, false)
+}
+
+// writeFrom queues the content of src, read only once what was queued
+// before is sent and copied straight to the connection, so a payload such
+// as an RDB file is never held in memory. Like writeUnlimited it isn't
+// accounted for by the output buffer limits. src is closed once consumed
+// or dropped when it is an io.Closer.
+func (c *Client) writeFrom(src io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isFake() || c.closing || c.killed {
+		discard([]outputChunk{{src: src}})
+		return errClientClosed
+	}
+
+	c.pending = append(c.pending, outputChunk{src: src})
+	c.cond.Signal()
+	return nil
+}
+
+func (c *Client) write(data string, limited bool) error {
+	if data == "" || c.isFake() {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing || c.killed {
+		return errClientClosed
+	}
+
+	c.pending = append(c.pending, outputChunk{data: data, limited: limited})
+	if limited {
+		c.pendingBytes += int64(len(data))
+	}
+
+	if c.overLimit() {
+		log.Printf("client %s (%s) scheduled to be closed ASAP for overcoming of output buffer limits", c.Conn.RemoteAddr(), c.Class)
+		c.kill()
+		return errClientClosed
+	}
+
+	c.cond.Signal()
+	return nil
+}
+
+// overLimit reports whether the output buffer crossed the hard limit of
+// the client class or has stayed above the soft limit for too long. It must
+// be called with c.mu held.
+func (c *Client) overLimit() bool {
+	limit := c.server.outputBufferLimit(c.Class)
+
+	if limit.Hard > 0 && c.pendingBytes >= limit.Hard {
+		return true
+	}
+
+	if limit.Soft == 0 || c.pendingBytes < limit.Soft {
+		c.softLimitSince = time.Time{}
+		return false
+	}
+
+	if c.softLimitSince.IsZero() {
+		c.softLimitSince = time.Now()
+		return false
+	}
+
+	return time.Since(c.softLimitSince) > limit.SoftDuration
+}
+
+// kill drops the connection without flushing. It must be called with c.mu
+// held.
+func (c *Client) kill() {
+	c.killed = true
+	discard(c.pending)
+	c.pending = nil
+	c.Conn.Close()
+	c.cond.Signal()
+}
+
+// Close flushes the pending output then closes the connection.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closing = true
+	c.cond.Signal()
+}
+
+func (c *Client) writeLoop() {
+	for {
+		c.mu.Lock()
+		for len(c.pending) == 0 && !c.closing && !c.killed {
+			c.cond.Wait()
+		}
+
+		if c.killed || len(c.pending) == 0 {
+			c.mu.Unlock()
+			c.Conn.Close()
+			return
+		}
+
+		batch := c.pending
+		c.pending = nil
+		c.mu.Unlock()
+
+		var written int64
+		for i, chunk := range batch {
+			if err := c.send(chunk); err != nil {
+				discard(batch[i+1:])
+				c.mu.Lock()
+				c.kill()
+				c.mu.Unlock()
+				return
+			}
+
+			if chunk.limited {
+				written += int64(len(chunk.data))
+			}
+		}
+
+		c.mu.Lock()
+		c.pendingBytes -= written
+		c.overLimit() // resets the soft limit timer once drained enough
+		c.mu.Unlock()
+	}
+}
+
+// send writes chunk to the connection.
+func (c *Client) send(chunk outputChunk) error {
+	if chunk.src == nil {
+		_, err := c.Conn.Write([]byte(chunk.data))
+		return err
+	}
+
+	_, err := io.Copy(c.Conn, chunk.src)
+	discard([]outputChunk{chunk})
+	return err
+}
+
+// outputBufferLimit is one class entry of client-output-buffer-limit. A zero
+// limit is disabled.
+type outputBufferLimit struct {
+	Hard         int64
+	Soft         int64
+	SoftDuration time.Duration
+}
+
+func (s *Server) outputBufferLimit(class clientClass) outputBufferLimit {
+	s.ConfigMux.RLock()
+	defer s.ConfigMux.RUnlock()
+
+	return s.outputLimits[class]
+}
+
+// applyOutputBufferLimits updates the limits of the classes given in value,
+// keeping those of the others like redis does, and stores the resulting
+// client-output-buffer-limit, listing every class.
+func applyOutputBufferLimits(s *Server, value string) error {
+	limits, err := parseOutputBufferLimits(value)
+	if err != nil {
+		return err
+	}
+
+	s.ConfigMux.Lock()
+	defer s.ConfigMux.Unlock()
+
+	merged := map[clientClass]outputBufferLimit{}
+	if s.outputLimits == nil {
+		s.outputLimits, _ = parseOutputBufferLimits(configParams["client-output-buffer-limit"].Default)
+	}
+
+	for class, limit := range s.outputLimits {
+		merged[class] = limit
+	}
+
+	for class, limit := range limits {
+		merged[class] = limit
+	}
+
+	var groups []string
+	for _, class := range []clientClass{clientClassNormal, clientClassReplica, clientClassPubSub} {
+		limit := merged[class]
+		groups = append(groups, fmt.Sprintf("%s %d %d %d", class, limit.Hard, limit.Soft, int64(limit.SoftDuration/time.Second)))
+	}
+
+	s.outputLimits = merged
+	s.Config["client-output-buffer-limit"] = strings.Join(groups, " ")
+	return nil
+}
+
+// parseOutputBufferLimits parses client-output-buffer-limit, a list of
+// "<class> <hard limit> <soft limit> <soft seconds>" groups.
+func parseOutputBufferLimits(v string) (map[clientClass]outputBufferLimit, error) {
+	fields := strings.Fields(v)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments")
+	}
+
+	limits := map[clientClass]outputBufferLimit{}
+	for i := 0; i < len(fields); i += 4 {
+		var class clientClass
+		switch strings.ToLower(fields[i]) {
+		case "normal":
+			class = clientClassNormal
+		case "replica", "slave":
+			class = clientClassReplica
+		case "pubsub":
+			class = clientClassPubSub
+		default:
+			return nil, fmt.Errorf("invalid client class '%s'", fields[i])
+		}
+
+		hard, err := parseMemory(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		soft, err := parseMemory(fields[i+2])
+		if err != nil {
+			return nil, err
+		}
+
+		seconds, err := strconv.Atoi(fields[i+3])
+		if err != nil || seconds < 0 {
+			return nil, errors.New("invalid soft limit seconds")
+		}
+
+		limits[class] = outputBufferLimit{
+			Hard:         hard,
+			Soft:         soft,
+			SoftDuration: time.Duration(seconds) * time.Second,
+		}
+	}
+
+	return limits, nil
+}
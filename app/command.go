@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+type commandFlag uint
+
+const (
+	flagWrite commandFlag = 1 << iota
+	flagReadonly
+	flagAdmin
+	flagNoScript
+	flagBlocking
+	flagPubSub
+	flagFast
+	flagLoading // allowed while the dataset is loading
+	flagStale   // allowed on a replica with stale data, see replica-serve-stale-data
+)
+
+var commandFlagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadonly, "readonly"},
+	{flagAdmin, "admin"},
+	{flagNoScript, "noscript"},
+	{flagBlocking, "blocking"},
+	{flagPubSub, "pubsub"},
+	{flagFast, "fast"},
+	{flagLoading, "loading"},
+	{flagStale, "stale"},
+}
+
+type commandHandler func(s *Server, c *Client, args []string) string
+
+// commandSpec describes a command the server knows about. Arity follows the
+// redis convention: a positive value is the exact number of arguments
+// including the command name, a negative value -N means at least N.
+// FirstKey, LastKey and KeyStep locate the keys among the arguments
+// (1-based, counting the command name as 0), LastKey -1 meaning the last
+// argument.
+type commandSpec struct {
+	Name     string
+	Arity    int
+	Flags    commandFlag
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	Group    string
+	Summary  string
+	Since    string
+	Handler  commandHandler
+}
+
+func (c *commandSpec) has(flag commandFlag) bool {
+	return c.Flags&flag != 0
+}
+
+func (c *commandSpec) flagNames() []string {
+	var names []string
+	for _, f := range commandFlagNames {
+		if c.has(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+
+	return names
+}
+
+func (c *commandSpec) aclCategories() []string {
+	var cats []string
+	switch {
+	case c.has(flagWrite):
+		cats = append(cats, "@write")
+	case c.has(flagReadonly):
+		cats = append(cats, "@read")
+	}
+
+	if c.has(flagAdmin) {
+		cats = append(cats, "@admin", "@dangerous")
+	}
+
+	if c.has(flagFast) {
+		cats = append(cats, "@fast")
+	} else {
+		cats = append(cats, "@slow")
+	}
+
+	if c.has(flagBlocking) {
+		cats = append(cats, "@blocking")
+	}
+
+	if c.has(flagPubSub) {
+		cats = append(cats, "@pubsub")
+	}
+
+	switch c.Group {
+	case "string", "connection":
+		cats = append(cats, "@"+c.Group)
+	case "generic":
+		cats = append(cats, "@keyspace")
+	}
+
+	return cats
+}
+
+func (c *commandSpec) checkArity(argc int) bool {
+	if c.Arity < 0 {
+		return argc >= -c.Arity
+	}
+
+	return argc == c.Arity
+}
+
+// keys returns the positions in args (command name excluded) holding keys.
+func (c *commandSpec) keys(args []string) []int {
+	if c.FirstKey == 0 {
+		return nil
+	}
+
+	last := c.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+
+	var pos []int
+	for i := c.FirstKey; i <= last && i <= len(args); i += c.KeyStep {
+		pos = append(pos, i-1)
+	}
+
+	return pos
+}
+
+var commandTable map[string]*commandSpec
+
+func init() {
+	commandTable = map[string]*commandSpec{}
+	for _, c := range []*commandSpec{
+		{Name: "ping", Arity: -1, Flags: flagFast | flagLoading, Group: "connection", Summary: "Returns the server's liveliness response.", Since: "1.0.0", Handler: (*Server).onPing},
+		{Name: "echo", Arity: 2, Flags: flagFast | flagLoading, Group: "connection", Summary: "Returns the given string.", Since: "1.0.0", Handler: (*Server).onEcho},
+		{Name: "set", Arity: -3, Flags: flagWrite, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", Since: "1.0.0", Handler: (*Server).onSet},
+		{Name: "get", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Returns the string value of a key.", Since: "1.0.0", Handler: (*Server).onGet},
+		{Name: "type", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Determines the type of value stored at a key.", Since: "1.0.0", Handler: (*Server).onType},
+		{Name: "object", Arity: -2, Flags: flagReadonly, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "generic", Summary: "A container for object introspection commands.", Since: "2.2.3", Handler: (*Server).onObject},
+		{Name: "keys", Arity: 2, Flags: flagReadonly, Group: "generic", Summary: "Returns all key names that match a pattern.", Since: "1.0.0", Handler: (*Server).onKeys},
+		{Name: "config", Arity: -2, Flags: flagAdmin | flagNoScript | flagLoading | flagStale, Group: "server", Summary: "A container for server configuration commands.", Since: "2.0.0", Handler: (*Server).onConfig},
+		{Name: "info", Arity: -1, Flags: flagLoading | flagStale, Group: "server", Summary: "Returns information and statistics about the server.", Since: "1.0.0", Handler: (*Server).onInfo},
+		{Name: "replconf", Arity: -1, Flags: flagAdmin | flagNoScript | flagLoading | flagStale, Group: "server", Summary: "An internal command for configuring the replication stream.", Since: "3.0.0", Handler: (*Server).onReplConf},
+		{Name: "psync", Arity: -3, Flags: flagAdmin | flagNoScript, Group: "server", Summary: "An internal command used in replication.", Since: "2.8.0", Handler: (*Server).onPsync},
+		{Name: "save", Arity: 1, Flags: flagAdmin | flagNoScript, Group: "server", Summary: "Synchronously saves the database(s) to disk.", Since: "1.0.0", Handler: (*Server).onSave},
+		{Name: "bgsave", Arity: -1, Flags: flagAdmin | flagNoScript, Group: "server", Summary: "Asynchronously saves the database(s) to disk.", Since: "1.0.0", Handler: (*Server).onBgsave},
+		{Name: "bgrewriteaof", Arity: 1, Flags: flagAdmin | flagNoScript, Group: "server", Summary: "Asynchronously rewrites the append-only file to disk.", Since: "1.0.0", Handler: (*Server).onBgrewriteaof},
+		{Name: "lastsave", Arity: 1, Flags: flagFast | flagLoading | flagStale, Group: "server", Summary: "Returns the Unix timestamp of the last successful save to disk.", Since: "1.0.0", Handler: (*Server).onLastsave},
+		{Name: "replicaof", Arity: 3, Flags: flagAdmin | flagNoScript | flagStale, Group: "server", Summary: "Configures a server as replica of another, or promotes it to a master.", Since: "5.0.0", Handler: (*Server).onReplicaOf},
+		{Name: "slaveof", Arity: 3, Flags: flagAdmin | flagNoScript | flagStale, Group: "server", Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.", Since: "1.0.0", Handler: (*Server).onReplicaOf},
+		{Name: "role", Arity: 1, Flags: flagNoScript | flagFast | flagLoading | flagStale, Group: "server", Summary: "Returns the replication role.", Since: "2.8.12", Handler: (*Server).onRole},
+		{Name: "wait", Arity: 3, Flags: flagBlocking, Group: "generic", Summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.", Since: "3.0.0", Handler: (*Server).onWait},
+		{Name: "waitaof", Arity: 4, Flags: flagBlocking | flagNoScript, Group: "generic", Summary: "Blocks until all of the preceding write commands sent by the connection are written to the append-only file of the master and/or replicas.", Since: "7.2.0", Handler: (*Server).onWaitAof},
+		{Name: "command", Arity: -1, Flags: flagLoading | flagStale, Group: "server", Summary: "Returns detailed information about all commands.", Since: "2.8.13", Handler: (*Server).onCommand},
+	} {
+		commandTable[c.Name] = c
+	}
+}
+
+func lookupCommand(name string) (*commandSpec, bool) {
+	c, ok := commandTable[strings.ToLower(name)]
+	return c, ok
+}
+
+// sortedCommands returns the command table ordered by name so replies are
+// stable.
+func sortedCommands() []*commandSpec {
+	cmds := make([]*commandSpec, 0, len(commandTable))
+	for _, c := range commandTable {
+		cmds = append(cmds, c)
+	}
+
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+func (s *Server) dispatchCommand(client *Client, c command) error {
+	return client.Write(s.execCommand(client, c))
+}
+
+// execCommand validates c against the command table and runs its handler,
+// returning the reply. Unknown commands and arity mismatches are answered
+// with an error instead of terminating the connection, and a panicking
+// handler only fails the current command.
+func (s *Server) execCommand(client *Client, c command) (reply string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic while running %q: %v", c.cmd, r)
+			reply = resp.EncodeError("ERR internal error while running '" + c.cmd + "'")
+		}
+	}()
+
+	spec, ok := lookupCommand(c.cmd)
+	if !ok {
+		return resp.EncodeError(unknownCommandError(c))
+	}
+
+	if !spec.checkArity(len(c.args) + 1) {
+		return resp.EncodeError(wrongArityError(c.cmd))
+	}
+
+	if atomic.LoadInt32(&s.loading) == 1 && !spec.has(flagLoading) && !client.isFake() {
+		return resp.EncodeError(errLoading)
+	}
+
+	if errMsg := s.checkReplicaAccess(client, spec); errMsg != "" {
+		return resp.EncodeError(errMsg)
+	}
+
+	if spec.has(flagWrite) && !s.enoughGoodReplicas(client) {
+		return resp.EncodeError(errNoReplicas)
+	}
+
+	if spec.has(flagWrite) && client.Class != clientClassMaster {
+		s.writeMu.RLock()
+		defer s.writeMu.RUnlock()
+	}
+
+	client.rewritten = nil
+	reply = spec.Handler(s, client, c.args)
+
+	// write commands are propagated on success, whatever they are, in their
+	// deterministic form when the handler rewrote them
+	if spec.has(flagWrite) && !strings.HasPrefix(reply, "-") {
+		propagated := c
+		if client.rewritten != nil {
+			propagated = *client.rewritten
+		}
+
+		atomic.AddInt64(&s.Dirty, 1)
+		s.feedAppendOnlyFile(propagated)
+
+		if client.Class != clientClassMaster {
+			client.woff = s.propagateCmdToReplicas(propagated)
+		}
+	}
+
+	return reply
+}
+
+const errLoading = "LOADING Redis is loading the dataset in memory"
+
+const errReadonly = "READONLY You can't write against a read only replica."
+
+const errMasterDown = "MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'."
+
+// checkReplicaAccess returns the error rejecting spec on a replica, for
+// the clients other than its master: writes when replica-read-only is
+// enabled and, unless replica-serve-stale-data is enabled, the commands
+// touching the dataset while it isn't in sync with the master.
+func (s *Server) checkReplicaAccess(client *Client, spec *commandSpec) string {
+	if !s.IsSlave.Load() || client.Class == clientClassMaster || client.isFake() {
+		return ""
+	}
+
+	if spec.has(flagWrite) && s.configBool("replica-read-only") {
+		return errReadonly
+	}
+
+	if !spec.has(flagStale) && s.linkState() != replStateConnected && !s.configBool("replica-serve-stale-data") {
+		return errMasterDown
+	}
+
+	return ""
+}
+
+const errNoReplicas = "NOREPLICAS Not enough good replicas to write."
+
+// enoughGoodReplicas reports whether a master has the min-replicas-to-write
+// replicas that acknowledged within min-replicas-max-lag seconds it needs
+// to accept writes, from the clients other than its master.
+func (s *Server) enoughGoodReplicas(client *Client) bool {
+	minReplicas, maxLag := s.configInt("min-replicas-to-write"), s.configInt("min-replicas-max-lag")
+	if minReplicas == 0 || maxLag == 0 || client.Class == clientClassMaster || client.isFake() {
+		return true
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	return s.IsSlave.Load() || int64(s.goodReplicas(maxLag)) >= minReplicas
+}
+
+const errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+func unknownCommandError(c command) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("ERR unknown command '%s', with args beginning with: ", c.cmd))
+	for _, arg := range c.args {
+		sb.WriteString(fmt.Sprintf("'%s' ", arg))
+	}
+
+	return sb.String()
+}
+
+func wrongArityError(cmd string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+func (s *Server) onCommand(client *Client, args []string) string {
+	if len(args) == 0 {
+		cmds := sortedCommands()
+		infos := make([]string, len(cmds))
+		for i, c := range cmds {
+			infos[i] = encodeCommandInfo(c)
+		}
+
+		return resp.EncodeArray(infos...)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "count":
+		if len(args) != 1 {
+			return resp.EncodeError(wrongArityError("command|count"))
+		}
+
+		return resp.EncodeInteger(len(commandTable))
+	case "info":
+		if len(args) == 1 {
+			return s.onCommand(client, nil)
+		}
+
+		infos := make([]string, 0, len(args)-1)
+		for _, name := range args[1:] {
+			c, ok := lookupCommand(name)
+			if !ok {
+				infos = append(infos, resp.EncodeNullArray())
+				continue
+			}
+
+			infos = append(infos, encodeCommandInfo(c))
+		}
+
+		return resp.EncodeArray(infos...)
+	case "docs":
+		cmds := sortedCommands()
+		if len(args) > 1 {
+			cmds = cmds[:0]
+			for _, name := range args[1:] {
+				if c, ok := lookupCommand(name); ok {
+					cmds = append(cmds, c)
+				}
+			}
+		}
+
+		docs := make([]string, 0, len(cmds)*2)
+		for _, c := range cmds {
+			docs = append(docs, resp.EncodeBulkString(c.Name), encodeCommandDocs(c))
+		}
+
+		return resp.EncodeArray(docs...)
+	case "list":
+		return onCommandList(args[1:])
+	case "getkeys":
+		if len(args) < 2 {
+			return resp.EncodeError(wrongArityError("command|getkeys"))
+		}
+
+		c, ok := lookupCommand(args[1])
+		if !ok {
+			return resp.EncodeError("ERR Invalid command specified")
+		}
+
+		cmdArgs := args[2:]
+		if !c.checkArity(len(cmdArgs) + 1) {
+			return resp.EncodeError("ERR Invalid number of arguments specified for command")
+		}
+
+		pos := c.keys(cmdArgs)
+		if len(pos) == 0 {
+			return resp.EncodeError("ERR The command has no key arguments")
+		}
+
+		keys := make([]string, len(pos))
+		for i, p := range pos {
+			keys[i] = cmdArgs[p]
+		}
+
+		return resp.EncodeBulkStrings(keys...)
+	default:
+		return resp.EncodeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0]))
+	}
+}
+
+// onCommandList implements COMMAND LIST [FILTERBY MODULE name | ACLCAT
+// category | PATTERN pattern].
+func onCommandList(args []string) string {
+	var match func(c *commandSpec) bool
+	switch {
+	case len(args) == 0:
+		match = func(*commandSpec) bool { return true }
+	case len(args) == 3 && strings.EqualFold(args[0], "filterby"):
+		value := args[2]
+		switch strings.ToLower(args[1]) {
+		case "module":
+			// there are no modules, nothing belongs to one
+			match = func(*commandSpec) bool { return false }
+		case "aclcat":
+			match = func(c *commandSpec) bool {
+				for _, cat := range c.aclCategories() {
+					if strings.EqualFold(cat[1:], value) {
+						return true
+					}
+				}
+				return false
+			}
+		case "pattern":
+			match = func(c *commandSpec) bool {
+				ok, _ := path.Match(value, c.Name)
+				return ok
+			}
+		default:
+			return resp.EncodeError("ERR syntax error")
+		}
+	default:
+		return resp.EncodeError("ERR syntax error")
+	}
+
+	var names []string
+	for _, c := range sortedCommands() {
+		if match(c) {
+			names = append(names, c.Name)
+		}
+	}
+
+	return resp.EncodeBulkStrings(names...)
+}
+
+// encodeCommandInfo encodes c in the shape of a COMMAND INFO entry: name,
+// arity, flags, first key, last key, step, ACL categories, tips, key specs
+// and subcommands.
+func encodeCommandInfo(c *commandSpec) string {
+	flags := c.flagNames()
+	encodedFlags := make([]string, len(flags))
+	for i, f := range flags {
+		encodedFlags[i] = resp.EncodeSimpleString(f)
+	}
+
+	cats := c.aclCategories()
+	encodedCats := make([]string, len(cats))
+	for i, cat := range cats {
+		encodedCats[i] = resp.EncodeSimpleString(cat)
+	}
+
+	return resp.EncodeArray(
+		resp.EncodeBulkString(c.Name),
+		resp.EncodeInteger(c.Arity),
+		resp.EncodeArray(encodedFlags...),
+		resp.EncodeInteger(c.FirstKey),
+		resp.EncodeInteger(c.LastKey),
+		resp.EncodeInteger(c.KeyStep),
+		resp.EncodeArray(encodedCats...),
+		resp.EncodeArray(),
+		encodeKeySpecs(c),
+		resp.EncodeArray(),
+	)
+}
+
+func encodeKeySpecs(c *commandSpec) string {
+	if c.FirstKey == 0 {
+		return resp.EncodeArray()
+	}
+
+	access := "RO"
+	if c.has(flagWrite) {
+		access = "RW"
+	}
+
+	lastKey := c.LastKey
+	if lastKey >= 0 {
+		lastKey -= c.FirstKey
+	}
+
+	spec := resp.EncodeArray(
+		resp.EncodeBulkString("flags"),
+		resp.EncodeArray(resp.EncodeSimpleString(access)),
+		resp.EncodeBulkString("begin_search"),
+		resp.EncodeArray(
+			resp.EncodeBulkString("type"), resp.EncodeBulkString("index"),
+			resp.EncodeBulkString("spec"), resp.EncodeArray(resp.EncodeBulkString("index"), resp.EncodeInteger(c.FirstKey)),
+		),
+		resp.EncodeBulkString("find_keys"),
+		resp.EncodeArray(
+			resp.EncodeBulkString("type"), resp.EncodeBulkString("range"),
+			resp.EncodeBulkString("spec"), resp.EncodeArray(
+				resp.EncodeBulkString("lastkey"), resp.EncodeInteger(lastKey),
+				resp.EncodeBulkString("keystep"), resp.EncodeInteger(c.KeyStep),
+				resp.EncodeBulkString("limit"), resp.EncodeInteger(0),
+			),
+		),
+	)
+
+	return resp.EncodeArray(spec)
+}
+
+func encodeCommandDocs(c *commandSpec) string {
+	return resp.EncodeArray(
+		resp.EncodeBulkString("summary"), resp.EncodeBulkString(c.Summary),
+		resp.EncodeBulkString("since"), resp.EncodeBulkString(c.Since),
+		resp.EncodeBulkString("group"), resp.EncodeBulkString(c.Group),
+	)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// configParam describes a parameter accepted by CONFIG GET/SET and by the
+// command line as --<name> <value>. Validate is optional and is called with
+// the raw value before it is stored. Apply is optional too and is called
+// when CONFIG SET changes the value at runtime, the previous value being
+// restored if it fails.
+type configParam struct {
+	Name     string
+	Default  string
+	Validate func(string) error
+	Apply    func(s *Server, value string) error
+}
+
+var configParams = map[string]*configParam{}
+
+func init() {
+	for _, p := range []*configParam{
+		{Name: "dir", Default: "."},
+		{Name: "dbfilename", Default: "dump.rdb"},
+		{Name: "save", Default: "3600 1 300 100 60 10000", Validate: validateSaveRules},
+		{Name: "rdbcompression", Default: "yes", Validate: validateBool},
+		{Name: "rdbchecksum", Default: "yes", Validate: validateBool},
+		{Name: "rdb-corrupt-start-empty", Default: "no", Validate: validateBool},
+		{Name: "appendonly", Default: "no", Validate: validateBool, Apply: applyAppendOnly},
+		{Name: "appendfilename", Default: "appendonly.aof", Validate: validateFileName},
+		{Name: "appenddirname", Default: "appendonlydir", Validate: validateFileName},
+		{Name: "appendfsync", Default: "everysec", Validate: validateAppendFsync},
+		{Name: "aof-load-truncated", Default: "yes", Validate: validateBool},
+		{Name: "aof-timestamp-enabled", Default: "no", Validate: validateBool},
+		{Name: "auto-aof-rewrite-percentage", Default: "100", Validate: validateNonNegativeInt},
+		{Name: "auto-aof-rewrite-min-size", Default: "64mb", Validate: validateMemory},
+		{Name: "repl-backlog-size", Default: "1mb", Validate: validateMemory, Apply: applyBacklogSize},
+		{Name: "repl-backlog-ttl", Default: "3600", Validate: validateNonNegativeInt},
+		{Name: "replica-read-only", Default: "yes", Validate: validateBool},
+		{Name: "replica-serve-stale-data", Default: "yes", Validate: validateBool},
+		{Name: "repl-diskless-sync", Default: "no", Validate: validateBool},
+		{Name: "repl-diskless-load", Default: "disabled", Validate: validateReplDisklessLoad},
+		{Name: "repl-timeout", Default: "60", Validate: validatePositiveInt},
+		{Name: "repl-ping-replica-period", Default: "10", Validate: validatePositiveInt},
+		{Name: "min-replicas-to-write", Default: "0", Validate: validateNonNegativeInt},
+		{Name: "min-replicas-max-lag", Default: "10", Validate: validateNonNegativeInt},
+		{Name: "proto-max-bulk-len", Default: "512mb", Validate: validateMemory},
+		{Name: "proto-max-multibulk-len", Default: "1048576", Validate: validatePositiveInt},
+		{Name: "proto-max-inline-len", Default: "64kb", Validate: validateMemory},
+		{Name: "client-query-buffer-limit", Default: "1gb", Validate: validateMemory},
+		{Name: "client-output-buffer-limit", Default: "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60", Validate: validateOutputBufferLimits, Apply: applyOutputBufferLimits},
+	} {
+		configParams[p.Name] = p
+	}
+}
+
+func defaultConfig() map[string]string {
+	config := map[string]string{}
+	for name, p := range configParams {
+		config[name] = p.Default
+	}
+
+	return config
+}
+
+func validatePositiveInt(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+		return errors.New("argument must be a positive integer")
+	}
+
+	return nil
+}
+
+func validateNonNegativeInt(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 0 {
+		return errors.New("argument must be a non-negative integer")
+	}
+
+	return nil
+}
+
+// validateFileName rejects paths and names the AOF manifest can't hold.
+func validateFileName(v string) error {
+	if v == "" || strings.ContainsAny(v, "/\\ \t\r\n") {
+		return errors.New("appendfilename and appenddirname can't be paths or contain spaces")
+	}
+
+	return nil
+}
+
+func validateMemory(v string) error {
+	_, err := parseMemory(v)
+	return err
+}
+
+func validateOutputBufferLimits(v string) error {
+	_, err := parseOutputBufferLimits(v)
+	return err
+}
+
+func validateBool(v string) error {
+	_, err := parseBool(v)
+	return err
+}
+
+// parseMemory parses sizes in the redis config format, e.g. "1gb", "64k" or
+// a plain number of bytes.
+func parseMemory(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	v = strings.ToLower(v)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+
+	return n * mul, nil
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+func (s *Server) getConfig(name string) string {
+	s.ConfigMux.RLock()
+	defer s.ConfigMux.RUnlock()
+
+	return s.Config[name]
+}
+
+func (s *Server) setConfig(name, value string) error {
+	p, ok := configParams[name]
+	if !ok {
+		return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+
+	if p.Validate != nil {
+		if err := p.Validate(value); err != nil {
+			return fmt.Errorf("Invalid argument '%s' for CONFIG SET '%s' - %s", value, name, err)
+		}
+	}
+
+	s.ConfigMux.Lock()
+	defer s.ConfigMux.Unlock()
+
+	s.Config[name] = value
+	return nil
+}
+
+// configInt returns an integer or memory parameter, values are validated
+// when set so parse errors can't happen here.
+func (s *Server) configInt(name string) int64 {
+	n, _ := parseMemory(s.getConfig(name))
+	return n
+}
+
+func (s *Server) configBool(name string) bool {
+	b, _ := parseBool(s.getConfig(name))
+	return b
+}
+
+func (s *Server) onConfig(c *Client, args []string) string {
+	switch strings.ToLower(args[0]) {
+	case "get":
+		if len(args) < 2 {
+			return resp.EncodeError(wrongArityError("config|get"))
+		}
+
+		var names []string
+		for _, pattern := range args[1:] {
+			for _, name := range sortedConfigNames() {
+				if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+					names = append(names, name)
+				}
+			}
+		}
+
+		kv := make([]string, 0, len(names)*2)
+		for _, name := range names {
+			kv = append(kv, name, s.getConfig(name))
+		}
+
+		return resp.EncodeBulkStrings(kv...)
+	case "set":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return resp.EncodeError(wrongArityError("config|set"))
+		}
+
+		for i := 1; i < len(args); i += 2 {
+			name, value := strings.ToLower(args[i]), args[i+1]
+			old := s.getConfig(name)
+			if err := s.setConfig(name, value); err != nil {
+				return resp.EncodeError("ERR " + err.Error())
+			}
+
+			if p := configParams[name]; p.Apply != nil && value != old {
+				if err := p.Apply(s, value); err != nil {
+					s.setConfig(name, old)
+					return resp.EncodeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err))
+				}
+			}
+		}
+
+		return "+OK\r\n"
+	}
+
+	return resp.EncodeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0]))
+}
+
+func sortedConfigNames() []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// rdbEOFMarkLen is the length of the random mark ending a snapshot
+// streamed without knowing its length up front, announced in the
+// $EOF:<mark> header.
+const rdbEOFMarkLen = 40
+
+func validateReplDisklessLoad(v string) error {
+	switch v {
+	case "disabled", "on-empty-db", "swapdb":
+		return nil
+	}
+
+	return errors.New("argument must be one of disabled, on-empty-db or swapdb")
+}
+
+// streamSnapshot sends snapshot to the replicas of the diskless resync
+// without saving it, between the $EOF:<mark> header and the mark. It is
+// encoded straight to their connections through pipes, as fast as they
+// read it, so only a small buffer of it is ever held in memory. They are
+// put online once they acknowledge having loaded it, see onlineAfterSync.
+func (s *Server) streamSnapshot(resync *replSync, snapshot rdb.RDB) {
+	var pipes []*io.PipeWriter
+	var writers []io.Writer
+
+	s.ReplicasMapMux.Lock()
+	for _, replica := range resync.Replicas {
+		pr, pw := io.Pipe()
+		replica.Client.writeFrom(pr)
+		pipes = append(pipes, pw)
+		writers = append(writers, pw)
+	}
+	s.ReplicasMapMux.Unlock()
+
+	mark := newReplicationID()
+	w := bufio.NewWriterSize(io.MultiWriter(writers...), 64*1024)
+	fmt.Fprintf(w, "$EOF:%s\r\n", mark)
+	err := rdb.Write(w, s.rdbOptions(), snapshot)
+	if err == nil {
+		w.WriteString(mark)
+		err = w.Flush()
+	}
+
+	for _, pw := range pipes {
+		pw.CloseWithError(err) // a nil error ends the payload
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if err != nil {
+		for _, replica := range resync.Replicas {
+			log.Printf("Diskless SYNC failed for replica %s: %v", replica.Addr, err)
+			replica.Close()
+		}
+		s.dropSync(resync)
+		return
+	}
+
+	resync.Streamed = true
+	for _, replica := range resync.Replicas {
+		log.Printf("Streamed RDB transfer with replica %s succeeded (socket). Waiting for REPLCONF ACK from replica to enable streaming", replica.Addr)
+	}
+}
+
+// onlineAfterSync puts replica online once it acknowledged the snapshot
+// streamed to it, sending it the writes buffered meanwhile. It must be
+// called with ReplicasMapMux held.
+func (s *Server) onlineAfterSync(replica *Replica) {
+	for _, resync := range s.replSyncs {
+		if !resync.Streamed {
+			continue
+		}
+
+		for i, r := range resync.Replicas {
+			if r != replica {
+				continue
+			}
+
+			for _, data := range resync.Buffer {
+				replica.send(data)
+			}
+
+			replica.Online = true
+			replica.AckTime = time.Now()
+			log.Printf("Synchronization with replica %s succeeded", replica.Addr)
+
+			resync.Replicas = append(resync.Replicas[:i], resync.Replicas[i+1:]...)
+			if len(resync.Replicas) == 0 {
+				s.dropSync(resync)
+			}
+			return
+		}
+	}
+}
+
+// deadlineReader reads from the master connection, which must keep
+// sending within timeout.
+type deadlineReader struct {
+	conn    net.Conn
+	r       io.Reader
+	timeout time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.conn.SetReadDeadline(time.Now().Add(d.timeout))
+	return d.r.Read(p)
+}
+
+// eofMarkReader reads a payload ended by mark, holding back the bytes that
+// may be the start of it. Nothing follows the mark until the replica
+// acknowledged the payload, so reading ahead is safe.
+type eofMarkReader struct {
+	r    io.Reader
+	mark []byte
+	buf  []byte
+	done bool
+}
+
+func (e *eofMarkReader) Read(p []byte) (int, error) {
+	chunk := make([]byte, 16*1024)
+	for !e.done && len(e.buf) <= len(e.mark) {
+		n, err := e.r.Read(chunk)
+		e.buf = append(e.buf, chunk[:n]...)
+		if bytes.HasSuffix(e.buf, e.mark) {
+			e.buf = e.buf[:len(e.buf)-len(e.mark)]
+			e.done = true
+			break
+		}
+
+		if errors.Is(err, io.EOF) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	avail := len(e.buf)
+	if !e.done {
+		avail -= len(e.mark)
+	}
+
+	if avail == 0 && e.done {
+		return 0, io.EOF
+	}
+
+	n := copy(p, e.buf[:avail])
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// receiveSnapshot reads the snapshot the master sends after FULLRESYNC,
+// either $<length> prefixed or, streamed without saving it first, between
+// $EOF:<mark> and the mark, eof reporting which. It is loaded from the
+// socket when repl-diskless-load allows, the current dataset staying until
+// it succeeds, and saved as the RDB file then loaded from it otherwise.
+func (s *Server) receiveSnapshot(conn *client.Conn, timeout time.Duration) (data rdb.RDB, eof bool, err error) {
+	r := conn.Reader()
+	line, _, err := resp.ReadUntilCRLF(r, 0) // read the $<length>\r\n
+	if err != nil {
+		return rdb.RDB{}, false, err
+	}
+
+	header := string(line)
+	var payload io.Reader
+	switch {
+	case strings.HasPrefix(header, "$EOF:"):
+		mark := header[len("$EOF:"):]
+		if len(mark) != rdbEOFMarkLen {
+			return rdb.RDB{}, false, fmt.Errorf("invalid rdb eof mark %q", mark)
+		}
+
+		eof = true
+		payload = &eofMarkReader{r: r, mark: []byte(mark)}
+	case strings.HasPrefix(header, "$"):
+		length, err := strconv.ParseInt(header[1:], 10, 64)
+		if err != nil {
+			return rdb.RDB{}, false, fmt.Errorf("invalid rdb length: %w", err)
+		}
+
+		// bounded so the commands streamed right after it aren't consumed
+		// by the rdb parser
+		payload = io.LimitReader(r, length)
+	default:
+		return rdb.RDB{}, false, fmt.Errorf("invalid rdb payload header %q", header)
+	}
+
+	// the master has to keep sending it within timeout, however long it is
+	payload = &deadlineReader{conn: conn.NetConn(), r: payload, timeout: timeout}
+
+	switch mode := s.getConfig("repl-diskless-load"); {
+	case mode == "swapdb", mode == "on-empty-db" && s.datasetEmpty():
+		log.Println("MASTER <-> REPLICA sync: Loading DB in memory")
+		data, err = rdb.ParseFile(bufio.NewReader(payload), s.rdbOptions())
+		if err == nil {
+			_, err = io.Copy(io.Discard, payload)
+		}
+	default:
+		log.Println("MASTER <-> REPLICA sync: receiving the RDB to disk")
+		data, err = s.saveSnapshot(payload)
+	}
+
+	return data, eof, err
+}
+
+// saveSnapshot writes the snapshot read from payload as the RDB file, then
+// loads it.
+func (s *Server) saveSnapshot(payload io.Reader) (rdb.RDB, error) {
+	path := s.rdbPath()
+	tmp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf("temp-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return rdb.RDB{}, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	_, err = io.Copy(tmp, payload)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return rdb.RDB{}, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return rdb.RDB{}, err
+	}
+
+	return s.loadRDBFile(path)
+}
+
+// datasetEmpty reports whether no database holds a key.
+func (s *Server) datasetEmpty() bool {
+	for _, db := range s.RDB.Databases {
+		if len(db.Keys()) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
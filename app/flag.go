@@ -2,7 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
 )
 
 type flag struct {
@@ -11,11 +15,22 @@ type flag struct {
 	port       int
 	masterAddr string
 	masterPort int
+
+	// recover asks for a point-in-time recovery of the AOF up to
+	// recoverUntil, saved to recoverOutput, instead of serving.
+	recover       bool
+	recoverUntil  aof.Point
+	recoverOutput string
+
+	// config holds any other --<name> <value> pair naming a config
+	// parameter.
+	config map[string]string
 }
 
 func parseFlag(args []string) (flag, error) {
 	flag := flag{
-		port: 6379, // default value
+		port:   6379, // default value
+		config: map[string]string{},
 	}
 	n := len(args)
 	for i := 0; i < len(args); i++ {
@@ -65,6 +80,47 @@ func parseFlag(args []string) (flag, error) {
 
 			flag.masterAddr = addr
 			flag.masterPort = port
+
+		case "--aof-recover-to-timestamp", "--aof-recover-to-command":
+			name := args[i]
+			i++
+			if n-i < 1 {
+				return flag, fmt.Errorf("empty %s", strings.TrimPrefix(name, "--"))
+			}
+
+			v, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || v < 1 {
+				return flag, fmt.Errorf("invalid %s", strings.TrimPrefix(name, "--"))
+			}
+
+			flag.recover = true
+			if name == "--aof-recover-to-timestamp" {
+				flag.recoverUntil.Timestamp = v
+			} else {
+				flag.recoverUntil.Commands = v
+			}
+
+		case "--aof-recover-output":
+			i++
+			if n-i < 1 {
+				return flag, errors.New("empty aof-recover-output")
+			}
+
+			flag.recover = true
+			flag.recoverOutput = args[i]
+
+		default:
+			name := strings.TrimPrefix(args[i], "--")
+			if _, ok := configParams[name]; !ok || name == args[i] {
+				continue
+			}
+
+			i++
+			if n-i < 1 {
+				return flag, fmt.Errorf("empty %s", name)
+			}
+
+			flag.config[name] = args[i]
 		}
 	}
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// infoSections lists the INFO sections in the order they are reported.
+var infoSections = []struct {
+	name  string
+	title string
+	build func(s *Server) []string
+}{
+	{"persistence", "Persistence", (*Server).infoPersistence},
+	{"replication", "Replication", (*Server).infoReplication},
+}
+
+func (s *Server) onInfo(c *Client, args []string) string {
+	wanted := map[string]bool{}
+	for _, arg := range args {
+		wanted[strings.ToLower(arg)] = true
+	}
+
+	all := len(args) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var sections []string
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+
+		lines := append([]string{"# " + section.title}, section.build(s)...)
+		sections = append(sections, strings.Join(lines, "\r\n")+"\r\n")
+	}
+
+	return resp.EncodeBulkString(strings.Join(sections, "\r\n"))
+}
+
+func (s *Server) infoReplication() []string {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	var lines []string
+	if s.IsSlave.Load() {
+		lines = append([]string{"role:slave"}, s.infoMasterLink()...)
+	} else {
+		lines = append([]string{"role:master"}, s.infoReplicas()...)
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("master_replid:%s", s.ReplicationID),
+		fmt.Sprintf("master_replid2:%s", s.ReplicationID2),
+		fmt.Sprintf("master_repl_offset:%d", s.ReplicationOffset),
+		fmt.Sprintf("second_repl_offset:%d", s.SecondReplOffset),
+	)
+
+	if s.backlog == nil {
+		return append(lines,
+			"repl_backlog_active:0",
+			fmt.Sprintf("repl_backlog_size:%d", s.configInt("repl-backlog-size")),
+			"repl_backlog_first_byte_offset:0",
+			"repl_backlog_histlen:0",
+		)
+	}
+
+	return append(lines,
+		"repl_backlog_active:1",
+		fmt.Sprintf("repl_backlog_size:%d", len(s.backlog.buf)),
+		fmt.Sprintf("repl_backlog_first_byte_offset:%d", s.backlog.firstByteOffset()),
+		fmt.Sprintf("repl_backlog_histlen:%d", s.backlog.histlen),
+	)
+}
+
+// infoReplicas returns the INFO replication lines about the replicas of
+// this master. It must be called with ReplicasMapMux held.
+func (s *Server) infoReplicas() []string {
+	lines := []string{fmt.Sprintf("connected_slaves:%d", len(s.Replicas))}
+
+	if minReplicas, maxLag := s.configInt("min-replicas-to-write"), s.configInt("min-replicas-max-lag"); minReplicas > 0 && maxLag > 0 {
+		lines = append(lines, fmt.Sprintf("min_slaves_good_slaves:%d", s.goodReplicas(maxLag)))
+	}
+
+	for i, replica := range s.Replicas {
+		ip, _, _ := net.SplitHostPort(replica.Addr)
+
+		state := "wait_bgsave"
+		if replica.Online {
+			state = "online"
+		}
+
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d",
+			i, ip, replica.Port, state, replica.AckOffset, replica.lag()))
+	}
+
+	return lines
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+var errBgsaveInProgress = errors.New("Background save already in progress")
+
+// saveRule is one "<seconds> <changes>" pair of the save config: a
+// background save is triggered once at least Changes writes happened and
+// Seconds elapsed since the last save.
+type saveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+func parseSaveRules(v string) ([]saveRule, error) {
+	fields := strings.Fields(v)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save parameters")
+	}
+
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return nil, errors.New("invalid save parameters")
+		}
+
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, errors.New("invalid save parameters")
+		}
+
+		rules = append(rules, saveRule{Seconds: seconds, Changes: changes})
+	}
+
+	return rules, nil
+}
+
+func validateSaveRules(v string) error {
+	_, err := parseSaveRules(v)
+	return err
+}
+
+func (s *Server) rdbPath() string {
+	return filepath.Join(s.getConfig("dir"), s.getConfig("dbfilename"))
+}
+
+// snapshot copies every database so it can be written out while clients
+// keep modifying the live ones. Write commands are held off while it is
+// taken so the replication offset it records matches its data.
+func (s *Server) snapshot() rdb.RDB {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.snapshotLocked()
+}
+
+// snapshotLocked is snapshot for callers already holding writeMu.
+func (s *Server) snapshotLocked() rdb.RDB {
+	s.ReplicasMapMux.Lock()
+	replID, offset := s.ReplicationID, s.ReplicationOffset
+	s.ReplicasMapMux.Unlock()
+
+	return s.snapshotAt(replID, offset)
+}
+
+// snapshotAt is snapshot for callers holding writeMu and ReplicasMapMux,
+// replID and offset being the replication ID and offset they read.
+func (s *Server) snapshotAt(replID string, offset int) rdb.RDB {
+	dbs := make([]*rdb.Database, len(s.RDB.Databases))
+	for i, db := range s.RDB.Databases {
+		dbs[i] = db.Snapshot()
+	}
+
+	return rdb.RDB{
+		AuxField:  s.rdbAuxFields(replID, offset),
+		Functions: s.RDB.Functions,
+		ModuleAux: s.RDB.ModuleAux,
+		Databases: dbs,
+	}
+}
+
+func (s *Server) rdbOptions() rdb.Options {
+	return rdb.Options{
+		Compression: s.configBool("rdbcompression"),
+		Checksum:    s.configBool("rdbchecksum"),
+	}
+}
+
+func (s *Server) rdbAuxFields(replID string, offset int) map[string]string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return map[string]string{
+		rdb.AuxFieldRedisVer:     "7.2.0",
+		rdb.AuxFieldRedisBits:    strconv.Itoa(strconv.IntSize),
+		rdb.AuxFieldCtime:        strconv.FormatInt(time.Now().Unix(), 10),
+		rdb.AuxFieldUsedMem:      strconv.FormatUint(mem.Alloc, 10),
+		rdb.AuxFieldReplStreamDB: strconv.Itoa(defaultCurrentDB),
+		rdb.AuxFieldReplID:       replID,
+		rdb.AuxFieldReplOffset:   strconv.Itoa(offset),
+		rdb.AuxFieldAOFBase:      "0",
+	}
+}
+
+// restoreReplicationInfo resumes the replication ID and offset saved in
+// the loaded RDB so replicas, or this server as a replica, can continue
+// with a partial resynchronization after a restart.
+func (s *Server) restoreReplicationInfo(data rdb.RDB) {
+	id, ok := data.AuxField[rdb.AuxFieldReplID]
+	if !ok || len(id) != 40 {
+		return
+	}
+
+	offset, err := strconv.Atoi(data.AuxField[rdb.AuxFieldReplOffset])
+	if err != nil {
+		return
+	}
+
+	s.ReplicationID = id
+	s.ReplicationOffset = offset
+	s.cachedMaster = true
+	log.Printf("Restored replication ID %s and offset %d from RDB", id, offset)
+}
+
+// loadRDBFile parses the RDB file at path, keeping the loading progress
+// reported by INFO up to date.
+func (s *Server) loadRDBFile(path string) (rdb.RDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return rdb.RDB{}, err
+	}
+	defer file.Close()
+
+	r, err := s.loadingReader(file)
+	if err != nil {
+		return rdb.RDB{}, err
+	}
+
+	return rdb.ParseFile(r, s.rdbOptions())
+}
+
+// loadingReader returns a reader of file that keeps track of the loading
+// progress.
+func (s *Server) loadingReader(file *os.File) (*bufio.Reader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt64(&s.loadingStart, time.Now().UnixMilli())
+	atomic.StoreInt64(&s.loadingTotal, info.Size())
+	atomic.StoreInt64(&s.loadingLoaded, 0)
+
+	return bufio.NewReader(&progressReader{r: file, n: &s.loadingLoaded}), nil
+}
+
+// progressReader counts the bytes read from r into n.
+type progressReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	atomic.AddInt64(p.n, int64(n))
+	return n, err
+}
+
+// writeRDBFile writes dbs to path atomically: the data goes to a temporary
+// file in the same directory that replaces path only once fully synced.
+func (s *Server) writeRDBFile(path string, snapshot rdb.RDB) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf("temp-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	if err := rdb.Write(w, s.rdbOptions(), snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// save writes a snapshot of the dataset to dir/dbfilename.
+func (s *Server) save() error {
+	dirty := atomic.LoadInt64(&s.Dirty)
+	start := time.Now()
+
+	err := s.writeRDBFile(s.rdbPath(), s.snapshot())
+	s.recordSave(dirty, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("DB saved on disk in %s", time.Since(start))
+	return nil
+}
+
+// bgsave starts saving a snapshot taken right away in the background.
+func (s *Server) bgsave() error {
+	if !atomic.CompareAndSwapInt32(&s.bgsaveInProgress, 0, 1) {
+		return errBgsaveInProgress
+	}
+
+	s.runBgsave(atomic.LoadInt64(&s.Dirty), s.snapshot(), nil)
+	return nil
+}
+
+// runBgsave saves snapshot, which includes dirty changes, to dir/dbfilename
+// in the background, then calls done, if not nil, with the path saved to
+// and the outcome. The caller sets bgsaveInProgress, cleared once done
+// returns so the file isn't replaced meanwhile.
+func (s *Server) runBgsave(dirty int64, snapshot rdb.RDB, done func(path string, err error)) {
+	path := s.rdbPath()
+
+	log.Println("Background saving started")
+	go func() {
+		defer atomic.StoreInt32(&s.bgsaveInProgress, 0)
+
+		err := s.writeRDBFile(path, snapshot)
+		s.recordSave(dirty, err)
+		if err != nil {
+			log.Println("Background saving error:", err)
+		} else {
+			log.Println("Background saving terminated with success")
+		}
+
+		if done != nil {
+			done(path, err)
+		}
+	}()
+}
+
+// recordSave updates the save statistics, dirty being the number of changes
+// the saved snapshot includes.
+func (s *Server) recordSave(dirty int64, err error) {
+	if err != nil {
+		atomic.StoreInt32(&s.lastSaveFailed, 1)
+		return
+	}
+
+	atomic.StoreInt32(&s.lastSaveFailed, 0)
+	atomic.AddInt64(&s.Dirty, -dirty)
+	atomic.StoreInt64(&s.LastSave, time.Now().Unix())
+}
+
+// runSaveRules triggers background saves according to the save config
+// until ctx is done.
+func (s *Server) runSaveRules(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rules, _ := parseSaveRules(s.getConfig("save"))
+		dirty := atomic.LoadInt64(&s.Dirty)
+		elapsed := time.Now().Unix() - atomic.LoadInt64(&s.LastSave)
+
+		for _, rule := range rules {
+			if dirty >= rule.Changes && dirty > 0 && elapsed >= rule.Seconds {
+				log.Printf("%d changes in %d seconds. Saving...", rule.Changes, rule.Seconds)
+				if err := s.bgsave(); err != nil && !errors.Is(err, errBgsaveInProgress) {
+					log.Println(err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (s *Server) onSave(c *Client, args []string) string {
+	if atomic.LoadInt32(&s.bgsaveInProgress) == 1 {
+		return resp.EncodeError("ERR " + errBgsaveInProgress.Error())
+	}
+
+	if err := s.save(); err != nil {
+		log.Println("Failed saving the DB:", err)
+		return resp.EncodeError("ERR " + err.Error())
+	}
+
+	return "+OK\r\n"
+}
+
+func (s *Server) onBgsave(c *Client, args []string) string {
+	if len(args) > 1 || (len(args) == 1 && !strings.EqualFold(args[0], "schedule")) {
+		return resp.EncodeError("ERR syntax error")
+	}
+
+	if err := s.bgsave(); err != nil {
+		return resp.EncodeError("ERR " + err.Error())
+	}
+
+	return "+Background saving started\r\n"
+}
+
+func (s *Server) onLastsave(c *Client, args []string) string {
+	return resp.EncodeInteger(int(atomic.LoadInt64(&s.LastSave)))
+}
+
+func (s *Server) infoPersistence() []string {
+	status := "ok"
+	if atomic.LoadInt32(&s.lastSaveFailed) == 1 {
+		status = "err"
+	}
+
+	lines := []string{
+		fmt.Sprintf("loading:%d", atomic.LoadInt32(&s.loading)),
+		"async_loading:0",
+	}
+
+	if atomic.LoadInt32(&s.loading) == 1 {
+		lines = append(lines, s.infoLoading()...)
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("rdb_changes_since_last_save:%d", atomic.LoadInt64(&s.Dirty)),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", atomic.LoadInt32(&s.bgsaveInProgress)),
+		fmt.Sprintf("rdb_last_save_time:%d", atomic.LoadInt64(&s.LastSave)),
+		fmt.Sprintf("rdb_last_bgsave_status:%s", status),
+	)
+
+	return append(lines, s.infoAppendOnly()...)
+}
+
+// infoLoading reports the progress of the dataset being loaded, the ETA
+// assuming the rest loads as fast as what was already loaded.
+func (s *Server) infoLoading() []string {
+	start := atomic.LoadInt64(&s.loadingStart)
+	total := atomic.LoadInt64(&s.loadingTotal)
+	loaded := atomic.LoadInt64(&s.loadingLoaded)
+	elapsed := time.Since(time.UnixMilli(start)).Seconds()
+
+	var perc float64
+	if total > 0 {
+		perc = float64(loaded) * 100 / float64(total)
+	}
+
+	eta := 1
+	if loaded > 0 {
+		eta = int(elapsed * float64(total-loaded) / float64(loaded))
+	}
+
+	return []string{
+		fmt.Sprintf("loading_start_time:%d", start/1000),
+		fmt.Sprintf("loading_total_bytes:%d", total),
+		fmt.Sprintf("loading_loaded_bytes:%d", loaded),
+		fmt.Sprintf("loading_loaded_perc:%.2f", perc),
+		fmt.Sprintf("loading_eta_seconds:%d", eta),
+	}
+}
@@ -1,19 +1,40 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
 )
 
 type Replica struct {
-	Addr string
-	Port int
-	Conn net.Conn
+	Addr   string
+	Port   int
+	Client *Client
+
+	// Online is set once the replica got the snapshot of its full
+	// resynchronization, it is sent the write commands from then on.
+	Online bool
+
+	// the offsets the replica acknowledged with REPLCONF ACK, as applied
+	// and as fsynced to its AOF, and when it last did
+	AckOffset    int
+	AofAckOffset int
+	AckTime      time.Time
 }
 
-func (r *Replica) SendCommand(cmd command) {
-	msg := EncodeBulkStrings(append([]string{cmd.cmd}, cmd.args...)...)
-	_, err := r.Conn.Write([]byte(msg))
+// lag returns the seconds elapsed since the replica last acknowledged.
+func (r *Replica) lag() int64 {
+	return int64(time.Since(r.AckTime).Seconds())
+}
+
+func (r *Replica) send(data string) {
+	err := r.Client.Write(data)
 	if err != nil {
 		log.Println("Error sending message to replica:", err.Error())
 		return
@@ -21,5 +42,301 @@ func (r *Replica) SendCommand(cmd command) {
 }
 
 func (r *Replica) Close() {
-	r.Conn.Close()
+	r.Client.Close()
+}
+
+// replSync is a full resynchronization in progress: the snapshot taken
+// for it is being saved while the write commands propagated since then are
+// buffered, to be sent after it to the replicas waiting for it. Replicas
+// attaching meanwhile share it, the buffer holding every write they miss
+// in the snapshot.
+type replSync struct {
+	Offset      int
+	Replicas    []*Replica
+	Buffer      []string
+	BufferBytes int64
+
+	// Diskless is set for a snapshot streamed to its replica instead of
+	// being saved first, that no other replica can share. Streamed is set
+	// once it is sent, the buffer following when the replica acknowledges
+	// having loaded it.
+	Diskless bool
+	Streamed bool
+}
+
+// replicaOf returns the replica attached through c, if any. It must be
+// called with ReplicasMapMux held.
+func (s *Server) replicaOf(c *Client) *Replica {
+	for _, replica := range s.Replicas {
+		if replica.Client == c {
+			return replica
+		}
+	}
+
+	return nil
+}
+
+// attachReplica returns the replica attached through c, registering it if
+// it didn't send REPLCONF listening-port, e.g. redis-cli --rdb. It must be
+// called with ReplicasMapMux held.
+func (s *Server) attachReplica(c *Client) *Replica {
+	if replica := s.replicaOf(c); replica != nil {
+		return replica
+	}
+
+	c.SetClass(clientClassReplica)
+	replica := &Replica{Addr: c.Conn.RemoteAddr().String(), Client: c, AckTime: time.Now()}
+	s.Replicas = append(s.Replicas, replica)
+	s.noReplicasSince = time.Time{}
+
+	return replica
+}
+
+// ensureBacklog creates the backlog if there is none yet, once the first
+// replica attaches. It must be called with ReplicasMapMux held.
+func (s *Server) ensureBacklog() {
+	if s.backlog == nil {
+		s.backlog = newReplBacklog(s.configInt("repl-backlog-size"), s.ReplicationOffset)
+	}
+}
+
+// partialResync continues the replication stream of the replica attached
+// through c from offset, when replID is the current replication ID, or the
+// previous one up to the offset it was valid for, and the backlog still
+// holds the stream from there. It reports whether it did.
+func (s *Server) partialResync(c *Client, replID, offset string) bool {
+	psyncOffset, err := strconv.Atoi(offset)
+	if err != nil || replID == "?" {
+		return false
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if replID != s.ReplicationID && (replID != s.ReplicationID2 || psyncOffset > s.SecondReplOffset) {
+		log.Printf("Partial resynchronization not accepted: Replication ID mismatch (Replica asked for '%s', my replication IDs are '%s' and '%s')",
+			replID, s.ReplicationID, s.ReplicationID2)
+		return false
+	}
+
+	s.ensureBacklog()
+	data, ok := s.backlog.since(psyncOffset)
+	if !ok {
+		log.Printf("Unable to partial resync with replica %s for lack of backlog (Replica request was: %d)", c.Conn.RemoteAddr(), psyncOffset)
+		return false
+	}
+
+	replica := s.attachReplica(c)
+	replica.Online = true
+	replica.AckTime = time.Now()
+	c.Write("+CONTINUE " + s.ReplicationID + "\r\n")
+	replica.send(data)
+
+	log.Printf("Partial resynchronization request from %s accepted. Sending %d bytes of backlog starting from offset %d",
+		replica.Addr, len(data), psyncOffset)
+	return true
+}
+
+// feedReplicationStream appends data to the replication stream, advancing
+// the replication offset: to the backlog, the online replicas and the full
+// resynchronization in progress. Without backlog, as long as no replica
+// attached, there is no stream. It must be called with ReplicasMapMux
+// held.
+func (s *Server) feedReplicationStream(data string) {
+	if s.backlog == nil {
+		return
+	}
+
+	s.backlog.write(data)
+	s.ReplicationOffset += len(data)
+
+	for _, replica := range s.Replicas {
+		if replica.Online {
+			replica.send(data)
+		}
+	}
+
+	s.bufferForSync(data)
+}
+
+// proxyMasterStream feeds data, received from our master, to the
+// replication stream of our own replicas.
+func (s *Server) proxyMasterStream(data string) {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	s.feedReplicationStream(data)
+}
+
+// runReplicationCron runs the periodic replication jobs until ctx is done.
+func (s *Server) runReplicationCron(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for elapsed := int64(1); ; elapsed++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if period := s.configInt("repl-ping-replica-period"); period > 0 && elapsed%period == 0 {
+			s.pingReplicas()
+		}
+
+		s.expireBacklog()
+	}
+}
+
+// expireBacklog frees the backlog of a master left without replicas for
+// repl-backlog-ttl seconds, unless the AOF needs it for WAITAOF.
+func (s *Server) expireBacklog() {
+	ttl := s.configInt("repl-backlog-ttl")
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if s.IsSlave.Load() || s.backlog == nil || len(s.Replicas) > 0 || ttl == 0 || s.noReplicasSince.IsZero() || s.configBool("appendonly") {
+		return
+	}
+
+	if time.Since(s.noReplicasSince) < time.Duration(ttl)*time.Second {
+		return
+	}
+
+	// the stream is lost, a new ID keeps replicas from trying to continue it
+	s.backlog = nil
+	s.ReplicationID = newReplicationID()
+	s.ReplicationID2 = emptyReplicationID
+	s.SecondReplOffset = -1
+	log.Printf("Replication backlog freed after %d seconds without connected replicas", ttl)
+}
+
+// fullResync sends +FULLRESYNC to the replica attached through c, then the
+// snapshot of the dataset followed by the writes executed since it was
+// taken, once it is saved. It returns the replication offset the snapshot
+// is at.
+func (s *Server) fullResync(c *Client) int {
+	// no write is in flight while the snapshot is taken, so it holds
+	// exactly what was propagated before it
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	s.ensureBacklog()
+	replica := s.attachReplica(c)
+	replica.Online = false
+
+	diskless := s.configBool("repl-diskless-sync") && c.replCapaEOF
+	for _, resync := range s.replSyncs {
+		if !diskless && !resync.Diskless {
+			log.Printf("Waiting for end of BGSAVE for SYNC of replica %s", replica.Addr)
+			c.Write(fmt.Sprintf("+FULLRESYNC %s %d\r\n", s.ReplicationID, resync.Offset))
+			resync.Replicas = append(resync.Replicas, replica)
+			return resync.Offset
+		}
+	}
+
+	resync := &replSync{Offset: s.ReplicationOffset, Replicas: []*Replica{replica}, Diskless: diskless}
+	s.replSyncs = append(s.replSyncs, resync)
+	c.Write(fmt.Sprintf("+FULLRESYNC %s %d\r\n", s.ReplicationID, resync.Offset))
+
+	if diskless {
+		log.Printf("Starting diskless SYNC with replica %s", replica.Addr)
+		go s.streamSnapshot(resync, s.snapshotAt(s.ReplicationID, s.ReplicationOffset))
+		return resync.Offset
+	}
+
+	log.Printf("Starting BGSAVE for SYNC with replica %s", replica.Addr)
+	go s.saveForReplication(resync, atomic.LoadInt64(&s.Dirty), s.snapshotAt(s.ReplicationID, s.ReplicationOffset))
+
+	return resync.Offset
+}
+
+// saveForReplication saves snapshot with a BGSAVE, once the one in progress
+// if any is done, and sends it to the replicas of resync.
+func (s *Server) saveForReplication(resync *replSync, dirty int64, snapshot rdb.RDB) {
+	for !atomic.CompareAndSwapInt32(&s.bgsaveInProgress, 0, 1) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.runBgsave(dirty, snapshot, func(path string, err error) {
+		s.finishFullResync(resync, path, err)
+	})
+}
+
+// finishFullResync sends the RDB file at path followed by the buffered
+// writes to the replicas of resync, which are online from then on. They are
+// disconnected when the snapshot couldn't be saved, to try again. The file
+// is streamed from disk to each of them, opened before the next save can
+// replace it.
+func (s *Server) finishFullResync(resync *replSync, path string, err error) {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	s.dropSync(resync)
+
+	for _, replica := range resync.Replicas {
+		var payload *os.File
+		var info os.FileInfo
+		if err == nil {
+			payload, err = os.Open(path)
+		}
+		if err == nil {
+			if info, err = payload.Stat(); err != nil {
+				payload.Close()
+			}
+		}
+
+		if err != nil {
+			log.Printf("SYNC failed for replica %s: %v", replica.Addr, err)
+			replica.Close()
+			continue
+		}
+
+		replica.Client.writeUnlimited(fmt.Sprintf("$%d\r\n", info.Size()))
+		replica.Client.writeFrom(payload)
+		for _, data := range resync.Buffer {
+			replica.send(data)
+		}
+
+		replica.Online = true
+		replica.AckTime = time.Now()
+		log.Printf("Synchronization with replica %s succeeded", replica.Addr)
+	}
+}
+
+// bufferForSync keeps a write propagated during a full resynchronization
+// for the replicas waiting for its snapshot, dropping them if it grows past
+// the hard output buffer limit of replicas. It must be called with
+// ReplicasMapMux held.
+func (s *Server) bufferForSync(data string) {
+	limit := s.outputBufferLimit(clientClassReplica)
+
+	for _, resync := range append([]*replSync(nil), s.replSyncs...) {
+		resync.Buffer = append(resync.Buffer, data)
+		resync.BufferBytes += int64(len(data))
+
+		if limit.Hard > 0 && resync.BufferBytes >= limit.Hard {
+			for _, replica := range resync.Replicas {
+				log.Printf("replica %s scheduled to be closed ASAP for overcoming of output buffer limits", replica.Addr)
+				replica.Close()
+			}
+			resync.Replicas = nil
+			s.dropSync(resync)
+		}
+	}
+}
+
+// dropSync forgets the full resynchronization resync, done or failed. It
+// must be called with ReplicasMapMux held.
+func (s *Server) dropSync(resync *replSync) {
+	for i, r := range s.replSyncs {
+		if r == resync {
+			s.replSyncs = append(s.replSyncs[:i], s.replSyncs[i+1:]...)
+			return
+		}
+	}
 }
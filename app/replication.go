@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// replState is where a replica stands in the replication with its master.
+type replState int32
+
+const (
+	replStateNone       replState = iota // not a replica
+	replStateConnect                     // waiting to connect to the master
+	replStateConnecting                  // connecting and running the handshake
+	replStateTransfer                    // receiving the snapshot of the master
+	replStateConnected                   // applying the stream of the master
+)
+
+// the delay before connecting again to the master after the link failed
+// doubles on each failure, from replMinRetryDelay up to replMaxRetryDelay.
+const (
+	replMinRetryDelay = 500 * time.Millisecond
+	replMaxRetryDelay = 30 * time.Second
+)
+
+// errMasterTimeout is returned by HandleMaster when the master sent
+// nothing, not even a PING, for repl-timeout seconds.
+var errMasterTimeout = errors.New("MASTER timeout: no data nor PING received")
+
+func (s *Server) linkState() replState {
+	return replState(atomic.LoadInt32(&s.replLinkState))
+}
+
+func (s *Server) setLinkState(state replState) {
+	atomic.StoreInt32(&s.replLinkState, int32(state))
+}
+
+// replTimeout returns repl-timeout as a duration.
+func (s *Server) replTimeout() time.Duration {
+	return time.Duration(s.configInt("repl-timeout")) * time.Second
+}
+
+// touchMasterLink records that data was just received from the master.
+func (s *Server) touchMasterLink() {
+	atomic.StoreInt64(&s.masterLastIO, time.Now().UnixNano())
+}
+
+// runReplication keeps this replica connected to its master at addr until
+// ctx is done: it connects, synchronizes with the master and applies its
+// stream, then starts over after a growing delay whenever the link fails.
+// The replication ID and offset reached are kept to continue from with a
+// partial resynchronization.
+func (s *Server) runReplication(ctx context.Context, addr string) {
+	delay := replMinRetryDelay
+	for {
+		if !s.setRunLinkState(ctx, replStateConnecting) {
+			return
+		}
+
+		conn, err := s.connectToMaster(ctx, addr)
+		if err == nil {
+			delay = replMinRetryDelay
+			log.Printf("MASTER <-> REPLICA sync: connected to master %s", addr)
+
+			err = s.HandleMaster(conn.NetConn(), conn.Reader())
+			conn.Close()
+		}
+
+		if !s.setRunLinkState(ctx, replStateConnect) {
+			return
+		}
+
+		log.Printf("Connection with master lost: %v, retrying in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > replMaxRetryDelay {
+			delay = replMaxRetryDelay
+		}
+	}
+}
+
+// setRunLinkState sets the link state on behalf of the replication run of
+// ctx, recording when the link went down as it leaves replStateConnected.
+// Once REPLICAOF stopped the run the state belongs to the next one, or to
+// the master this server became, so nothing changes and it returns false.
+func (s *Server) setRunLinkState(ctx context.Context, state replState) bool {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if s.linkState() == replStateConnected && state != replStateConnected {
+		atomic.StoreInt64(&s.masterLinkDownSince, time.Now().UnixNano())
+	}
+
+	s.setLinkState(state)
+	return true
+}
+
+// startReplication makes this server a replica of the master at
+// host:port, in place of the one it replicates if any. A master turning
+// into a replica first tries to continue its own replication stream from
+// the new master, that has it as secondary ID after a failover. It must be
+// called with ReplicasMapMux held.
+func (s *Server) startReplication(host string, port int) {
+	if !s.IsSlave.Load() {
+		s.cachedMaster = true
+	}
+
+	s.stopReplication()
+	s.IsSlave.Store(true)
+	s.MasterAddress = host
+	s.MasterPort = port
+	s.setLinkState(replStateConnect)
+	atomic.StoreInt64(&s.masterLinkDownSince, time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(s.baseCtx)
+	s.replCancel = cancel
+	go s.runReplication(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// stopReplication stops replicating the master, if any. It must be called
+// with ReplicasMapMux held.
+func (s *Server) stopReplication() {
+	if s.replCancel != nil {
+		s.replCancel()
+		s.replCancel = nil
+	}
+
+	if s.MasterConn != nil {
+		s.MasterConn.Close()
+		s.MasterConn = nil
+	}
+}
+
+// promoteToMaster turns this replica into a master keeping its dataset. It
+// goes on with a new replication ID, the one of its former master staying
+// valid up to the current offset for replicas of the same master to
+// continue from. It must be called with ReplicasMapMux held.
+func (s *Server) promoteToMaster() {
+	s.stopReplication()
+	s.IsSlave.Store(false)
+	s.MasterAddress = ""
+	s.MasterPort = 0
+	s.setLinkState(replStateNone)
+	atomic.StoreInt64(&s.masterLinkDownSince, 0)
+
+	s.shiftReplicationID(newReplicationID())
+	s.ensureBacklog()
+	log.Printf("MASTER MODE enabled, new replication ID %s", s.ReplicationID)
+}
+
+// shiftReplicationID makes id the replication ID, the current one being
+// kept as the secondary ID valid up to the current offset. It must be
+// called with ReplicasMapMux held.
+func (s *Server) shiftReplicationID(id string) {
+	s.ReplicationID2 = s.ReplicationID
+	s.SecondReplOffset = s.ReplicationOffset + 1
+	s.ReplicationID = id
+}
+
+// disconnectReplicas drops the replicas, so they synchronize again with
+// the replication ID that changed. It must be called with ReplicasMapMux
+// held.
+func (s *Server) disconnectReplicas() {
+	for _, replica := range s.Replicas {
+		replica.Close()
+	}
+}
+
+func (s *Server) onReplicaOf(c *Client, args []string) string {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		if s.IsSlave.Load() {
+			s.promoteToMaster()
+		}
+		return "+OK\r\n"
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port < 0 || port > 65535 {
+		return resp.EncodeError("ERR Invalid master port")
+	}
+
+	if s.IsSlave.Load() && s.MasterAddress == args[0] && s.MasterPort == port {
+		return "+OK Already connected to specified master\r\n"
+	}
+
+	s.startReplication(args[0], port)
+	s.disconnectReplicas()
+	log.Printf("REPLICAOF %s:%d enabled (user request from %s)", args[0], port, c.Conn.RemoteAddr())
+
+	return "+OK\r\n"
+}
+
+// replStateNames are the replication states as ROLE reports them.
+var replStateNames = map[replState]string{
+	replStateConnect:    "connect",
+	replStateConnecting: "connecting",
+	replStateTransfer:   "sync",
+	replStateConnected:  "connected",
+}
+
+func (s *Server) onRole(c *Client, args []string) string {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if s.IsSlave.Load() {
+		return resp.EncodeArray(
+			resp.EncodeBulkString("slave"),
+			resp.EncodeBulkString(s.MasterAddress),
+			resp.EncodeInteger(s.MasterPort),
+			resp.EncodeBulkString(replStateNames[s.linkState()]),
+			resp.EncodeInteger(s.ReplicationOffset),
+		)
+	}
+
+	var replicas []string
+	for _, replica := range s.Replicas {
+		ip, _, _ := net.SplitHostPort(replica.Addr)
+		replicas = append(replicas, resp.EncodeBulkStrings(ip, strconv.Itoa(replica.Port), strconv.Itoa(replica.AckOffset)))
+	}
+
+	return resp.EncodeArray(
+		resp.EncodeBulkString("master"),
+		resp.EncodeInteger(s.ReplicationOffset),
+		resp.EncodeArray(replicas...),
+	)
+}
+
+// replconfAck returns the REPLCONF ACK telling our master the replication
+// offset applied, and the one fsynced to the AOF when it is enabled.
+func (s *Server) replconfAck() string {
+	s.ReplicasMapMux.Lock()
+	offset := s.ReplicationOffset
+	s.ReplicasMapMux.Unlock()
+
+	if fsynced := s.fsyncedOffset(); fsynced >= 0 {
+		return resp.EncodeBulkStrings("REPLCONF", "ACK", strconv.Itoa(offset), "FACK", strconv.Itoa(fsynced))
+	}
+
+	return resp.EncodeBulkStrings("REPLCONF", "ACK", strconv.Itoa(offset))
+}
+
+// streamRecorder keeps what is read from r until taken, so the commands
+// parsed from a buffered reader on top of it can be had as received.
+type streamRecorder struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (s *streamRecorder) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+// take returns the first n bytes recorded, forgetting them.
+func (s *streamRecorder) take(n int) string {
+	data := string(s.buf[:n])
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+	return data
+}
+
+// ackMaster sends REPLCONF ACK to our master through c every second until
+// done is closed, letting it know the lag of this replica.
+func (s *Server) ackMaster(c *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.Write(s.replconfAck()); err != nil {
+			return
+		}
+	}
+}
+
+// pingReplicas feeds a PING to the replication stream, letting the
+// replicas tell an idle master from a lost one.
+func (s *Server) pingReplicas() {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if s.IsSlave.Load() || len(s.Replicas) == 0 {
+		return
+	}
+
+	s.feedReplicationStream(command{cmd: "PING"}.encode())
+}
+
+// infoMasterLink returns the INFO replication lines about the link of
+// this replica with its master. It must be called with ReplicasMapMux held.
+func (s *Server) infoMasterLink() []string {
+	state := s.linkState()
+
+	status := "down"
+	if state == replStateConnected {
+		status = "up"
+	}
+
+	lastIO := int64(-1)
+	if ts := atomic.LoadInt64(&s.masterLastIO); ts != 0 {
+		lastIO = int64(time.Since(time.Unix(0, ts)).Seconds())
+	}
+
+	syncing := 0
+	if state == replStateTransfer {
+		syncing = 1
+	}
+
+	lines := []string{
+		fmt.Sprintf("master_host:%s", s.MasterAddress),
+		fmt.Sprintf("master_port:%d", s.MasterPort),
+		fmt.Sprintf("master_link_status:%s", status),
+		fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+		fmt.Sprintf("master_sync_in_progress:%d", syncing),
+	}
+
+	if ts := atomic.LoadInt64(&s.masterLinkDownSince); ts != 0 {
+		lines = append(lines, fmt.Sprintf("master_link_down_since_seconds:%d", int64(time.Since(time.Unix(0, ts)).Seconds())))
+	}
+
+	return lines
+}
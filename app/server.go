@@ -3,23 +3,27 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
 )
 
 var (
-	defaultCurrentDB = 0
-	emptyRDBB64      = `UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog==`
+	defaultCurrentDB   = 0
+	emptyReplicationID = strings.Repeat("0", 40)
 )
 
 func main() {
@@ -27,8 +31,10 @@ func main() {
 	fmt.Println("Logs from your program will appear here!")
 
 	s := &Server{
-		Config:        map[string]string{},
-		ReplicationID: "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb",
+		Config:           defaultConfig(),
+		ReplicationID:    newReplicationID(),
+		ReplicationID2:   emptyReplicationID,
+		SecondReplOffset: -1,
 	}
 
 	flag, err := parseFlag(os.Args)
@@ -37,51 +43,135 @@ func main() {
 	}
 
 	s.Port = flag.port
-	s.Config["dir"] = flag.dir
-	s.Config["dbfilename"] = flag.dbfilename
+	if flag.dir != "" {
+		s.Config["dir"] = flag.dir
+	}
+
+	if flag.dbfilename != "" {
+		s.Config["dbfilename"] = flag.dbfilename
+	}
+
+	for name, value := range flag.config {
+		if err := s.setConfig(name, value); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// a value given for some of the classes only keeps the default limits
+	// of the others
+	applyOutputBufferLimits(s, s.Config["client-output-buffer-limit"])
 
 	if flag.masterAddr != "" && flag.masterPort != 0 {
-		s.IsSlave = true
+		s.IsSlave.Store(true)
 		s.MasterAddress = flag.masterAddr
 		s.MasterPort = flag.masterPort
 	}
 
-	log.Println(s.Run(context.Background()))
+	if flag.recover {
+		if err := s.RecoverAOF(flag.recoverUntil, flag.recoverOutput); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if err := s.Run(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
 }
 
 type Server struct {
 	Addr              string
 	Port              int
 	Config            map[string]string
+	ConfigMux         sync.RWMutex
+	outputLimits      map[clientClass]outputBufferLimit // client-output-buffer-limit parsed, guarded by ConfigMux
 	ReplicationID     string
 	ReplicationOffset int
-	IsSlave           bool
+	IsSlave           atomic.Bool // changed with ReplicasMapMux held, read without it by commands
 	MasterAddress     string
 	MasterPort        int
 
-	MasterConn     net.Conn
-	Replicas       []*Replica
-	ReplicasMapMux sync.Mutex
-
-	RDB RDB
+	// ReplicationID2 is the replication ID of the master this server was
+	// replicating from before it changed, valid up to SecondReplOffset, so
+	// replicas of the same master can continue with a partial
+	// resynchronization after a failover (PSYNC2).
+	ReplicationID2   string
+	SecondReplOffset int
+
+	baseCtx             context.Context    // the server runs until it is done
+	replCancel          context.CancelFunc // stops the replication with the master
+	MasterConn          net.Conn
+	replLinkState       int32 // replState of the link with the master
+	masterLastIO        int64 // unix nanoseconds of the last data received from the master
+	masterLinkDownSince int64 // unix nanoseconds the link went down at, 0 while up
+	cachedMaster        bool  // the replication ID and offset continue a stream synced with before
+
+	Replicas        []*Replica
+	ReplicasMapMux  sync.Mutex
+	replSyncs       []*replSync  // full resynchronizations in progress
+	backlog         *replBacklog // created when the first replica attaches
+	noReplicasSince time.Time
+	acked           chan struct{} // closed when replicas acknowledge offsets
+
+	RDB rdb.RDB
+
+	Dirty            int64 // writes since the last successful save
+	LastSave         int64 // unix time of the last successful save
+	bgsaveInProgress int32
+	lastSaveFailed   int32
+
+	loading       int32 // 1 while the dataset is being loaded
+	loadingStart  int64 // unix milliseconds the load started at
+	loadingTotal  int64 // size of the file being loaded
+	loadingLoaded int64 // bytes of it loaded so far
+
+	// writeMu is held for reading by write commands, from their execution
+	// to their propagation, so a snapshot taken with it held for writing
+	// matches what was propagated so far.
+	writeMu sync.RWMutex
+
+	aofMu                sync.Mutex
+	aofManifest          *aof.Manifest
+	aofFile              *os.File // incr file written to, nil unless appendonly is enabled
+	aofUnsynced          bool     // written to since the last fsync
+	aofLastTimestamp     int64    // of the last timestamp annotation written
+	aofCurrentSize       int64
+	aofBaseSize          int64 // size right after the last rewrite or load
+	aofLastWriteFailed   int32
+	aofRewriteInProgress int32
+	aofFsyncedOffset     int64 // replication offset the AOF is fsynced up to
+	aofLastRewriteFailed int32
 }
 
 func (s *Server) Run(ctx context.Context) error {
-	s.LoadRDB()
-	if s.IsSlave {
-		err := s.connectToMaster()
-		if err != nil {
-			return err
-		}
-		log.Println("connected to master")
-	}
+	// clients are accepted while the dataset loads, data commands being
+	// answered with -LOADING until it's done
+	atomic.StoreInt32(&s.loading, 1)
+	s.baseCtx = ctx // before any command can start the replication
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.Addr, s.Port))
+	l, err := net.Listen("tcp", net.JoinHostPort(s.Addr, strconv.Itoa(s.Port)))
 	if err != nil {
 		return fmt.Errorf("failed to bind to port %d: %w", s.Port, err)
 	}
 	defer l.Close()
 
+	startErr := make(chan error, 1)
+	go func() {
+		// decoding failures are errors, anything else going wrong while
+		// loading still stops the server with an error rather than a crash
+		defer func() {
+			if r := recover(); r != nil {
+				startErr <- fmt.Errorf("panic while loading the dataset: %v", r)
+				l.Close()
+			}
+		}()
+
+		if err := s.start(ctx); err != nil {
+			startErr <- err
+			l.Close()
+		}
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -89,6 +179,11 @@ func (s *Server) Run(ctx context.Context) error {
 		default:
 			conn, err := l.Accept()
 			if err != nil {
+				select {
+				case err := <-startErr:
+					return err
+				default:
+				}
 				return fmt.Errorf("error accepting connection: %w", err)
 			}
 			go s.handleConnection(conn)
@@ -96,176 +191,352 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 }
 
-func (s *Server) LoadRDB() {
-	dir := s.Config["dir"]
-	filename := s.Config["dbfilename"]
-	path := filepath.Join(dir, filename)
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		db := &Database{}
-		db.ID = 0
-		db.Fields = map[string]Field{}
+// start loads the dataset then starts the background jobs and, for a
+// replica, the replication.
+func (s *Server) start(ctx context.Context) error {
+	if err := s.loadDataFromDisk(); err != nil {
+		return err
+	}
 
-		s.RDB.Databases = append(s.RDB.Databases, db)
+	atomic.StoreInt32(&s.loading, 0)
 
-		return
+	if s.configBool("appendonly") {
+		s.createBacklogForAOF()
 	}
 
-	if err != nil {
-		log.Fatal(err)
+	atomic.StoreInt64(&s.LastSave, time.Now().Unix())
+	go s.runSaveRules(ctx)
+	go s.runAppendOnlyCron(ctx)
+	go s.runReplicationCron(ctx)
+
+	s.ReplicasMapMux.Lock()
+	if s.IsSlave.Load() {
+		s.startReplication(s.MasterAddress, s.MasterPort)
 	}
+	s.ReplicasMapMux.Unlock()
 
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatal(err)
+	return nil
+}
+
+// loadDataFromDisk loads the AOF when appendonly is enabled and it exists,
+// the RDB file otherwise, then opens or creates the AOF if needed.
+func (s *Server) loadDataFromDisk() error {
+	if !s.configBool("appendonly") {
+		return s.LoadRDB()
 	}
 
-	rdb, err := ParseFile(bufio.NewReader(file))
+	m, err := s.loadAOFManifest()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	s.RDB = rdb
-}
+	if m == nil {
+		if m, err = s.upgradeAppendOnlyFile(); err != nil {
+			return err
+		}
+	}
 
-func (s *Server) connectToMaster() error {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", s.MasterAddress, s.MasterPort))
-	if err != nil {
+	if m == nil {
+		if err := s.LoadRDB(); err != nil {
+			return err
+		}
+
+		return s.startAppendOnly(true)
+	}
+
+	if err := s.LoadAOF(m, nil); err != nil {
 		return err
 	}
 
-	s.MasterConn = conn
+	return s.openAppendOnlyFile(m)
+}
 
-	r := bufio.NewReader(conn)
+// LoadRDB loads dir/dbfilename, starting with an empty dataset when it
+// doesn't exist, or when it is corrupt and rdb-corrupt-start-empty is
+// enabled.
+func (s *Server) LoadRDB() error {
+	path := s.rdbPath()
+	data, err := s.loadRDBFile(path)
+	if os.IsNotExist(err) {
+		s.setDataset(rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}})
+		return nil
+	}
 
-	_, err = conn.Write([]byte(EncodeBulkStrings("ping")))
-	if err != nil {
-		conn.Close()
-		return err
+	var rdbErr *rdb.RDBError
+	if errors.As(err, &rdbErr) && s.configBool("rdb-corrupt-start-empty") {
+		log.Printf("WARNING: failed loading %s: %v", path, err)
+		log.Printf("WARNING: starting with an empty dataset, the next save will overwrite %s", path)
+		s.setDataset(rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}})
+		return nil
 	}
 
-	_, _, err = parseMessage(r)
 	if err != nil {
-		conn.Close()
-		return err
+		return fmt.Errorf("failed loading %s: %w", path, err)
 	}
 
-	_, err = conn.Write([]byte(EncodeBulkStrings("replconf", "listening-port", strconv.Itoa(s.Port))))
-	if err != nil {
-		conn.Close()
-		return err
+	if ver, ok := data.AuxField[rdb.AuxFieldRedisVer]; ok {
+		log.Printf("Loading RDB produced by version %s", ver)
 	}
 
-	_, _, err = parseMessage(r)
-	if err != nil {
-		conn.Close()
-		return err
+	if ctime, err := strconv.ParseInt(data.AuxField[rdb.AuxFieldCtime], 10, 64); err == nil {
+		log.Printf("RDB age %d seconds", time.Now().Unix()-ctime)
 	}
 
-	_, err = conn.Write([]byte(EncodeBulkStrings("replconf", "capa", "psync2")))
-	if err != nil {
-		conn.Close()
-		return err
+	s.setDataset(data)
+	s.restoreReplicationInfo(data)
+	log.Printf("DB loaded from disk: %.3f seconds", time.Since(time.UnixMilli(atomic.LoadInt64(&s.loadingStart))).Seconds())
+
+	return nil
+}
+
+// setDataset replaces the dataset served with data, freshly loaded.
+func (s *Server) setDataset(data rdb.RDB) {
+	for _, db := range data.Databases {
+		db.ScheduleExpiries()
 	}
 
-	_, _, err = parseMessage(r)
+	s.RDB = data
+}
+
+// connectToMaster connects to the master at addr and synchronizes with it,
+// continuing from where this replica left off when the master still can.
+// Nothing changes once ctx is done, the replica being pointed elsewhere.
+func (s *Server) connectToMaster(ctx context.Context, addr string) (*client.Conn, error) {
+	timeout := s.replTimeout()
+	conn, err := client.DialTimeout(addr, timeout)
 	if err != nil {
-		conn.Close()
-		return err
+		return nil, err
 	}
 
-	_, err = conn.Write([]byte(EncodeBulkStrings("psync", "?", "-1")))
+	sync, err := s.handshakeMaster(ctx, conn, timeout)
 	if err != nil {
 		conn.Close()
-		return err
+		return nil, err
 	}
 
-	msg, _, err := parseMessage(r)
-	if err != nil {
+	conn.NetConn().SetDeadline(time.Time{})
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if err := ctx.Err(); err != nil {
 		conn.Close()
-		return err
+		return nil, err
+	}
+
+	// the stream of the master is kept in our backlog too, for our own
+	// replicas to continue from, a full resync starting a new one. They are
+	// dropped to resync with the new dataset, or with the new replication
+	// ID after a failover, ours staying valid up to here for them to
+	// continue from.
+	if sync.Full {
+		s.setDataset(sync.Data)
+		s.ReplicationID = sync.ReplID
+		s.ReplicationOffset = sync.Offset
+		s.ReplicationID2 = emptyReplicationID
+		s.SecondReplOffset = -1
+		s.backlog = nil
+		s.replSyncs = nil
+		s.disconnectReplicas()
+	} else if sync.ReplID != "" && sync.ReplID != s.ReplicationID {
+		s.shiftReplicationID(sync.ReplID)
+		s.disconnectReplicas()
+	}
+
+	s.ensureBacklog()
+	s.cachedMaster = true
+	s.MasterConn = conn.NetConn()
+	s.touchMasterLink()
+	s.setLinkState(replStateConnected)
+	atomic.StoreInt64(&s.masterLinkDownSince, 0)
+
+	// the master waits for us to have loaded a streamed dataset before it
+	// goes on with its stream
+	if sync.EOF {
+		ack := resp.EncodeBulkStrings("REPLCONF", "ACK", strconv.Itoa(s.ReplicationOffset))
+		if _, err := conn.NetConn().Write([]byte(ack)); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	if msg.Type != "simplestring" {
-		conn.Close()
-		return errors.New("expected simplestring message")
+	return conn, nil
+}
+
+// masterSync is the outcome of the replication handshake with a master.
+type masterSync struct {
+	// Full is set for a full resynchronization, Data being the dataset the
+	// master sent, at replication Offset, and EOF set when it was streamed
+	// with an EOF mark. The master continued its stream from where this
+	// replica left off otherwise.
+	Full   bool
+	EOF    bool
+	ReplID string
+	Offset int
+	Data   rdb.RDB
+}
+
+// handshakeMaster runs the replication handshake on conn for the
+// replication run of ctx and returns how the master synchronizes this
+// replica. Each step must complete within timeout.
+func (s *Server) handshakeMaster(ctx context.Context, conn *client.Conn, timeout time.Duration) (masterSync, error) {
+	conn.NetConn().SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Do("ping"); err != nil {
+		return masterSync{}, err
 	}
 
-	msgContents := strings.Split(msg.Content.(string), " ")
-	if len(msgContents) < 3 {
-		conn.Close()
-		return errors.New("invalid fullresync message")
+	if _, err := conn.Do("replconf", "listening-port", strconv.Itoa(s.Port)); err != nil {
+		return masterSync{}, err
+	}
+
+	// the dataset can always be streamed to us, repl-diskless-load only
+	// decides whether it is loaded from the socket or saved to disk first
+	if _, err := conn.Do("replconf", "capa", "eof", "capa", "psync2"); err != nil {
+		return masterSync{}, err
 	}
 
-	_, _, err = readUntilCRLF(r) // read the $<length>\r\n
+	// the replication ID and offset of a master this replica synced with
+	// before, or restored from the RDB, let it continue from where this
+	// replica left off instead of sending everything
+	replID, offset := "?", "-1"
+	s.ReplicasMapMux.Lock()
+	if s.cachedMaster {
+		replID, offset = s.ReplicationID, strconv.Itoa(s.ReplicationOffset+1)
+	}
+	s.ReplicasMapMux.Unlock()
+
+	reply, err := client.String(conn.Do("psync", replID, offset))
 	if err != nil {
-		conn.Close()
-		return err
+		return masterSync{}, err
 	}
 
-	go func() {
-		defer s.MasterConn.Close()
-		err := s.HandleMaster()
-		if err != nil {
-			log.Println(err)
+	msgContents := strings.Split(reply, " ")
+	if msgContents[0] == "CONTINUE" {
+		var sync masterSync
+		if len(msgContents) > 1 {
+			sync.ReplID = msgContents[1]
 		}
-	}()
+		return sync, nil
+	}
+
+	if len(msgContents) < 3 || msgContents[0] != "FULLRESYNC" {
+		return masterSync{}, errors.New("invalid fullresync message")
+	}
 
-	rdb, err := ParseFile(r)
+	masterOffset, err := strconv.Atoi(msgContents[2])
 	if err != nil {
-		conn.Close()
-		return err
+		return masterSync{}, fmt.Errorf("invalid fullresync offset: %w", err)
 	}
 
-	s.RDB = rdb
+	if !s.setRunLinkState(ctx, replStateTransfer) {
+		return masterSync{}, ctx.Err()
+	}
 
-	return nil
+	data, eof, err := s.receiveSnapshot(conn, timeout)
+	if err != nil {
+		return masterSync{}, err
+	}
+
+	return masterSync{Full: true, EOF: eof, ReplID: msgContents[1], Offset: masterOffset, Data: data}, nil
 }
 
-func (s *Server) HandleMaster() error {
-	r := bufio.NewReader(s.MasterConn)
+func (s *Server) HandleMaster(conn net.Conn, r *bufio.Reader) error {
+	master := newClient(s, conn)
+	master.SetClass(clientClassMaster)
+	defer master.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.ackMaster(master, done)
+
 	log.Println("waiting for command from master")
 
+	// the stream is proxied to our replicas byte for byte, whatever
+	// framing the master used, so their offsets match its own
+	rec := &streamRecorder{r: r}
+	r = bufio.NewReader(rec)
+
 	for {
-		cmd, n, err := parseCommand(r)
+		// the master PINGs us every repl-ping-replica-period when idle
+		conn.SetReadDeadline(time.Now().Add(s.replTimeout()))
+
+		cmd, n, err := parseCommand(r, resp.Limits{}) // the master is trusted
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return errMasterTimeout
+		}
 		if err != nil {
 			return err
 		}
 
+		s.touchMasterLink()
+
 		log.Println("received command from master", cmd.cmd, cmd.args)
 
-		var msg string
-		switch strings.ToLower(cmd.cmd) {
-		case "set":
-			_ = s.onSet(cmd.args) // do not send back respond to master
-		case "replconf":
-			msg = s.onSlaveReplConf(cmd.args)
+		// commands from the master are applied silently through the same
+		// path as the clients' ones, only REPLCONF (GETACK) expects an
+		// answer. They are proxied before writeMu is released so that a
+		// snapshot always matches the replication offset it is taken at.
+		s.writeMu.RLock()
+		msg := s.execCommand(master, cmd)
+		s.proxyMasterStream(rec.take(n))
+		s.writeMu.RUnlock()
+
+		if strings.HasPrefix(msg, "-") {
+			log.Printf("error applying %q from master: %s", cmd.cmd, strings.TrimSpace(msg[1:]))
 		}
 
-		log.Println("sending response to master:", msg)
-		_, err = s.MasterConn.Write([]byte(msg))
-		if err != nil {
-			return err
+		if strings.EqualFold(cmd.cmd, "replconf") {
+			log.Println("sending response to master:", msg)
+			if err := master.Write(msg); err != nil {
+				return err
+			}
 		}
+	}
+}
 
-		s.ReplicationOffset += n
+func (s *Server) protoLimits() resp.Limits {
+	return resp.Limits{
+		MaxBulkLen:      s.configInt("proto-max-bulk-len"),
+		MaxMultiBulkLen: s.configInt("proto-max-multibulk-len"),
+		MaxInlineLen:    s.configInt("proto-max-inline-len"),
+		MaxQueryLen:     s.configInt("client-query-buffer-limit"),
 	}
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
+	client := newClient(s, conn)
+	defer func() {
+		client.Close()
+		s.removeReplica(client)
+	}()
+
 	r := bufio.NewReader(conn)
 	for {
-		cmd, _, err := parseCommand(r)
+		cmd, _, err := parseCommand(r, s.protoLimits())
 		if errors.Is(err, io.EOF) {
 			break
 		}
 
+		if errors.Is(err, resp.ErrQueryBufferLimit) {
+			log.Printf("closing client %s that reached max query buffer length", conn.RemoteAddr())
+			return
+		}
+
+		var perr resp.ProtocolError
+		if errors.As(err, &perr) {
+			// a malformed request leaves the reader in an unknown state, so
+			// report it and drop the connection like redis does.
+			_ = client.Write(resp.EncodeError("ERR Protocol error: " + perr.Error()))
+			return
+		}
+
 		if err != nil {
 			fmt.Println("Error reading message:", err.Error())
 			return
 		}
 
-		err = s.runCommand(conn, cmd)
+		err = s.dispatchCommand(client, cmd)
 		if err != nil {
 			fmt.Println("Error running message:", err.Error())
 			return
@@ -273,163 +544,240 @@ func (s *Server) handleConnection(conn net.Conn) {
 	}
 }
 
-func (s *Server) runCommand(conn net.Conn, c command) error {
-	var resp string
-	switch cmd := strings.ToLower(c.cmd); cmd {
-	case "ping":
-		resp = "+PONG\r\n"
-	case "echo":
-		resp = fmt.Sprintf("+%v\r\n", c.args[0])
-	case "set":
-		resp = s.onSet(c.args)
-		s.propagateCmdToReplicas(c)
-	case "get":
-		resp = s.onGet(c.args)
-	case "config":
-		resp = s.onConfig(c.args)
-	case "keys":
-		resp = s.onKeys(c.args)
-	case "info":
-		resp = s.onInfo(c.args)
-	case "replconf":
-		resp = s.onMasterReplConf(conn, c.args)
-	case "psync":
-		resp = s.onPsync(c.args)
-	default:
-		return fmt.Errorf("unknown command")
-	}
-
-	_, err := conn.Write([]byte(resp))
-	return err
-}
-
-func (s *Server) addReplica(conn net.Conn, port int) {
+func (s *Server) addReplica(c *Client, port int) {
 	log.Println("adding replica")
+	c.SetClass(clientClassReplica)
 	replica := &Replica{
-		Addr: conn.RemoteAddr().String(),
-		Port: port,
-		Conn: conn,
+		Addr:    c.Conn.RemoteAddr().String(),
+		Port:    port,
+		Client:  c,
+		AckTime: time.Now(),
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if r := s.replicaOf(c); r != nil {
+		r.Port = port
+		return
 	}
 
 	s.Replicas = append(s.Replicas, replica)
+	s.noReplicasSince = time.Time{}
 }
 
-func (s *Server) propagateCmdToReplicas(cmd command) {
-	for _, replica := range s.Replicas {
-		replica := replica
-		replica.SendCommand(cmd)
+// removeReplica forgets the replica attached through c, if any.
+func (s *Server) removeReplica(c *Client) {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	for _, resync := range s.replSyncs {
+		for i, replica := range resync.Replicas {
+			if replica.Client == c {
+				resync.Replicas = append(resync.Replicas[:i], resync.Replicas[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for i, replica := range s.Replicas {
+		if replica.Client == c {
+			s.Replicas = append(s.Replicas[:i], s.Replicas[i+1:]...)
+			log.Println("removed replica", replica.Addr)
+			if len(s.Replicas) == 0 {
+				s.noReplicasSince = time.Now()
+			}
+			return
+		}
 	}
 }
 
-func (s *Server) onSet(args []string) string {
+// propagateCmdToReplicas feeds cmd to the replication stream and returns
+// the replication offset reached.
+func (s *Server) propagateCmdToReplicas(cmd command) int {
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	s.feedReplicationStream(cmd.encode())
+	return s.ReplicationOffset
+}
+
+func (s *Server) onSet(c *Client, args []string) string {
 	if len(args) < 2 {
-		return "-ERR wrong number of arguments for 'set' command\r\n"
+		return resp.EncodeError(wrongArityError("set"))
 	}
 
-	database := s.RDB.Databases[defaultCurrentDB]
+	var expireAt time.Time
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "ex", "px", "exat", "pxat":
+		default:
+			return resp.EncodeError("ERR syntax error")
+		}
+
+		i++
+		if i >= len(args) || !expireAt.IsZero() {
+			return resp.EncodeError("ERR syntax error")
+		}
+
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || n <= 0 {
+			return resp.EncodeError("ERR invalid expire time in 'set' command")
+		}
+
+		switch opt {
+		case "ex":
+			expireAt = time.Now().Add(time.Duration(n) * time.Second)
+		case "px":
+			expireAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+		case "exat":
+			expireAt = time.Unix(n, 0)
+		case "pxat":
+			expireAt = time.UnixMilli(n)
+		}
+	}
+
+	database := s.RDB.Database(defaultCurrentDB)
 	key := args[0]
 	val := args[1]
 	database.Set(key, val)
 
-	if len(args) == 4 {
-		ttl, err := strconv.ParseInt(args[3], 10, 64)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if !expireAt.IsZero() {
+		database.SetExpire(key, expireAt)
 
-		go func() {
-			<-time.After(time.Duration(ttl) * time.Millisecond)
-			database.Unset(key)
-		}()
+		// a relative TTL would expire later on a replica or when the AOF
+		// is replayed
+		c.rewriteCommand("SET", key, val, "PXAT", strconv.FormatInt(expireAt.UnixMilli(), 10))
 	}
+
 	return "+OK\r\n"
 }
 
-func (s *Server) onGet(args []string) string {
-	data, ok := s.RDB.Databases[defaultCurrentDB].Get(args[0])
+func (s *Server) onPing(c *Client, args []string) string {
+	if len(args) > 0 {
+		return resp.EncodeBulkString(args[0])
+	}
+
+	return "+PONG\r\n"
+}
+
+func (s *Server) onEcho(c *Client, args []string) string {
+	return resp.EncodeBulkString(args[0])
+}
+
+func (s *Server) onGet(c *Client, args []string) string {
+	db := s.RDB.Database(defaultCurrentDB)
+	data, ok := db.Get(args[0])
 
 	if !ok {
+		if t, exists := db.Type(args[0]); exists && t != rdb.FieldTypeString {
+			return resp.EncodeError(errWrongType)
+		}
 		return "$-1\r\n"
 	}
 
 	return fmt.Sprintf("+%v\r\n", data)
 }
 
-func (s *Server) onConfig(args []string) string {
-	key := args[1]
-	val := s.Config[key]
-
-	if len(val) == 0 {
-		return "$-1\r\n"
+func (s *Server) onType(c *Client, args []string) string {
+	t, ok := s.RDB.Database(defaultCurrentDB).Type(args[0])
+	if !ok {
+		return "+none\r\n"
 	}
 
-	return EncodeBulkStrings(key, val)
+	return resp.EncodeSimpleString(t.String())
 }
 
-func (s *Server) onKeys(args []string) string {
-	db := s.RDB.Databases[defaultCurrentDB]
-	switch args[0] {
-	case "*":
-		var sb strings.Builder
-		sb.WriteString(fmt.Sprintf("*%d\r\n", len(db.Fields)))
-		for k := range db.Fields {
-			sb.WriteString(EncodeBulkString(k))
-		}
-		return sb.String()
+func (s *Server) onObject(c *Client, args []string) string {
+	sub := strings.ToLower(args[0])
+	if sub == "help" {
+		return resp.EncodeBulkStrings(
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"FREQ <key>",
+			"    Return the access frequency index of the key <key>.",
+			"IDLETIME <key>",
+			"    Return the idle time of the key <key>.",
+			"HELP",
+			"    Print this help.",
+		)
 	}
-	return "*0"
-}
 
-func (s *Server) onInfo(args []string) string {
-	switch args[0] {
-	case "replication":
-		if s.IsSlave {
-			return EncodeBulkString("role:slave")
-		}
+	if len(args) != 2 || (sub != "idletime" && sub != "freq") {
+		return resp.EncodeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0]))
+	}
+
+	f, ok := s.RDB.Database(defaultCurrentDB).Lookup(args[1])
+	if !ok {
+		return "$-1\r\n"
+	}
 
-		return EncodeBulkString("role:master" + "\r\n" +
-			fmt.Sprintf("master_replid:%s", s.ReplicationID) + "\r\n" +
-			fmt.Sprintf("master_repl_offset:%d", s.ReplicationOffset))
+	if sub == "freq" {
+		return resp.EncodeInteger(int(f.Freq))
+	}
 
+	var idle time.Duration
+	if !f.LastAccess.IsZero() {
+		idle = time.Since(f.LastAccess)
 	}
 
-	return "$-1\r\n"
+	return resp.EncodeInteger(int(idle / time.Second))
 }
 
-func (s *Server) onMasterReplConf(conn net.Conn, args []string) string {
+func (s *Server) onKeys(c *Client, args []string) string {
+	db := s.RDB.Database(defaultCurrentDB)
 	switch args[0] {
+	case "*":
+		return resp.EncodeBulkStrings(db.Keys()...)
+	}
+	return "*0"
+}
+
+func (s *Server) onReplConf(c *Client, args []string) string {
+	switch strings.ToLower(args[0]) {
 	case "listening-port":
 		if len(args[1:]) < 1 {
-			return "-ERR wrong number of arguments for 'replconf' listening-port command\r\n"
+			return resp.EncodeError(wrongArityError("replconf"))
 		}
 
 		port, err := strconv.Atoi(args[1])
 		if err != nil {
-			return "-ERR invalid port number\r\n"
+			return resp.EncodeError("ERR invalid port number")
 		}
 
-		s.addReplica(conn, port)
+		s.addReplica(c, port)
 		return "+OK\r\n"
+	case "capa":
+		for i := 0; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "capa") && strings.EqualFold(args[i+1], "eof") {
+				c.replCapaEOF = true
+			}
+		}
+	case "getack": // sent by our master
+		return s.replconfAck()
+	case "ack": // sent by our replicas, answered with nothing
+		if len(args[1:]) < 1 {
+			return resp.EncodeError(wrongArityError("replconf"))
+		}
+
+		s.ackReplica(c, args[1:])
+		return ""
 	}
 
 	return "+OK\r\n"
 }
 
-func (s *Server) onSlaveReplConf(args []string) string {
-	switch strings.ToLower(args[0]) {
-	case "getack":
-		return EncodeBulkStrings("REPLCONF", "ACK", strconv.Itoa(s.ReplicationOffset))
+func (s *Server) onPsync(c *Client, args []string) string {
+	// a replica serves the dataset and the stream of its master, it has
+	// neither to offer before it is in sync with it
+	if s.IsSlave.Load() && s.linkState() != replStateConnected {
+		return resp.EncodeError("NOMASTERLINK Can't SYNC while not connected with my master")
 	}
 
-	return "+OK\r\n"
-}
-
-func (s *Server) onPsync(args []string) string {
-	emptyRDB, err := base64.StdEncoding.DecodeString(emptyRDBB64)
-	if err != nil {
-		return "-ERR failed to parse empty RDB"
+	// the reply is written ahead of the stream or the snapshot that follow
+	if !s.partialResync(c, args[0], args[1]) {
+		s.fullResync(c)
 	}
 
-	encodedEmptyRDB := fmt.Sprintf("$%d\r\n%s", len(emptyRDB), emptyRDB)
-	return fmt.Sprintf("+FULLRESYNC %s %d\r\n", s.ReplicationID, s.ReplicationOffset) + encodedEmptyRDB
+	return ""
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func (s *Server) onWait(c *Client, args []string) string {
+	if s.IsSlave.Load() {
+		return resp.EncodeError("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+	}
+
+	numreplicas, err := strconv.Atoi(args[0])
+	if err != nil {
+		return resp.EncodeError("ERR value is not an integer or out of range")
+	}
+
+	timeout, errMsg := parseWaitTimeout(args[1])
+	if errMsg != "" {
+		return resp.EncodeError(errMsg)
+	}
+
+	var acked int
+	s.waitAcks(timeout, func() bool {
+		acked = s.countAcks(c.woff, false)
+		return acked >= numreplicas
+	})
+
+	return resp.EncodeInteger(acked)
+}
+
+func (s *Server) onWaitAof(c *Client, args []string) string {
+	if s.IsSlave.Load() {
+		return resp.EncodeError("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+
+	numlocal, err := strconv.Atoi(args[0])
+	if err != nil {
+		return resp.EncodeError("ERR value is not an integer or out of range")
+	}
+
+	numreplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.EncodeError("ERR value is not an integer or out of range")
+	}
+
+	timeout, errMsg := parseWaitTimeout(args[2])
+	if errMsg != "" {
+		return resp.EncodeError(errMsg)
+	}
+
+	if numlocal > 0 && !s.configBool("appendonly") {
+		return resp.EncodeError("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	// with appendfsync always the write is already on disk, only the
+	// offset it reached has to be recorded
+	if s.getConfig("appendfsync") == "always" {
+		s.syncAppendOnlyFile()
+	}
+
+	var local, acked int
+	s.waitAcks(timeout, func() bool {
+		local = 0
+		if s.configBool("appendonly") && s.fsyncedOffset() >= c.woff {
+			local = 1
+		}
+		acked = s.countAcks(c.woff, true)
+		return local >= numlocal && acked >= numreplicas
+	})
+
+	return resp.EncodeArray(resp.EncodeInteger(local), resp.EncodeInteger(acked))
+}
+
+// parseWaitTimeout parses the timeout of WAIT and WAITAOF, in milliseconds,
+// 0 meaning forever.
+func parseWaitTimeout(arg string) (time.Duration, string) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "ERR timeout is not an integer or out of range"
+	}
+
+	if ms < 0 {
+		return 0, "ERR timeout is negative"
+	}
+
+	return time.Duration(ms) * time.Millisecond, ""
+}
+
+// countAcks returns the number of replicas that acknowledged offset, or
+// having it fsynced to their AOF when fsynced is set. It must be called
+// with ReplicasMapMux held.
+func (s *Server) countAcks(offset int, fsynced bool) int {
+	var n int
+	for _, replica := range s.Replicas {
+		acked := replica.AckOffset
+		if fsynced {
+			acked = replica.AofAckOffset
+		}
+
+		if replica.Online && acked >= offset {
+			n++
+		}
+	}
+
+	return n
+}
+
+// goodReplicas returns the number of online replicas that acknowledged
+// within maxLag seconds. It must be called with ReplicasMapMux held.
+func (s *Server) goodReplicas(maxLag int64) int {
+	var n int
+	for _, replica := range s.Replicas {
+		if replica.Online && replica.lag() <= maxLag {
+			n++
+		}
+	}
+
+	return n
+}
+
+// waitAcks blocks until reached, called with ReplicasMapMux held, returns
+// true or timeout elapses, 0 meaning forever. It is called again each time
+// a replica acknowledges an offset or the AOF is fsynced. The replicas are
+// asked for their offset with REPLCONF GETACK once, they acknowledge it
+// every second anyway.
+func (s *Server) waitAcks(timeout time.Duration, reached func() bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	if reached() {
+		return
+	}
+
+	if s.acked == nil {
+		s.acked = make(chan struct{})
+	}
+
+	s.requestAcks()
+
+	for !reached() {
+		acked := s.acked
+		s.ReplicasMapMux.Unlock()
+
+		select {
+		case <-acked:
+			s.ReplicasMapMux.Lock()
+		case <-expired:
+			s.ReplicasMapMux.Lock()
+			reached()
+			return
+		}
+	}
+}
+
+// requestAcks asks the replicas for the offset they reached, they answer
+// with REPLCONF ACK. A replica leaves the stream it proxies untouched. It
+// must be called with ReplicasMapMux held.
+func (s *Server) requestAcks() {
+	if !s.IsSlave.Load() && len(s.Replicas) > 0 {
+		s.feedReplicationStream(command{cmd: "REPLCONF", args: []string{"GETACK", "*"}}.encode())
+	}
+}
+
+// notifyAcks wakes up the clients waiting in WAIT and WAITAOF. It must be
+// called with ReplicasMapMux held.
+func (s *Server) notifyAcks() {
+	if s.acked != nil {
+		close(s.acked)
+	}
+	s.acked = make(chan struct{})
+}
+
+// ackReplica records the offsets acknowledged by the replica attached
+// through c with REPLCONF ACK <offset> [FACK <aofoffset>].
+func (s *Server) ackReplica(c *Client, args []string) {
+	offset, err := strconv.Atoi(args[0])
+	if err != nil {
+		return
+	}
+
+	aofOffset := -1
+	if len(args) >= 3 && strings.EqualFold(args[1], "FACK") {
+		if n, err := strconv.Atoi(args[2]); err == nil {
+			aofOffset = n
+		}
+	}
+
+	s.ReplicasMapMux.Lock()
+	defer s.ReplicasMapMux.Unlock()
+
+	replica := s.replicaOf(c)
+	if replica == nil {
+		return
+	}
+
+	if offset > replica.AckOffset {
+		replica.AckOffset = offset
+	}
+	if aofOffset > replica.AofAckOffset {
+		replica.AofAckOffset = aofOffset
+	}
+	replica.AckTime = time.Now()
+
+	if !replica.Online {
+		s.onlineAfterSync(replica)
+	}
+
+	s.notifyAcks()
+}
+
+// fsyncedOffset returns the replication offset the AOF is fsynced up to,
+// -1 when it is disabled.
+func (s *Server) fsyncedOffset() int {
+	if !s.configBool("appendonly") {
+		return -1
+	}
+
+	return int(atomic.LoadInt64(&s.aofFsyncedOffset))
+}
@@ -0,0 +1,214 @@
+// Package client talks to a redis compatible server, sharing the RESP
+// encoder and decoder of the server in this repository.
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Error is an error reply sent by the server, e.g. "ERR unknown command".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Conn is a single connection to the server. Commands are either run one at
+// a time with Do, or pipelined with Send, Flush and Receive. A Conn is not
+// meant to be used from several goroutines at once, although the methods
+// are serialized so misuse doesn't corrupt the stream.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	mu      sync.Mutex
+	pending int   // replies owed for commands already sent
+	err     error // first connection level error, the Conn is unusable after it
+}
+
+func Dial(addr string) (*Conn, error) {
+	return DialTimeout(addr, 0)
+}
+
+func DialTimeout(addr string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn), nil
+}
+
+// NewConn wraps an established connection.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Reader returns the buffered reader replies are decoded from, for callers
+// that need to consume a non RESP payload following a reply, such as the
+// RDB file sent after PSYNC.
+func (c *Conn) Reader() *bufio.Reader {
+	return c.r
+}
+
+// Err returns the error that made the connection unusable, if any.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) fatal(err error) error {
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+
+	return err
+}
+
+// Send buffers a command without waiting for its reply.
+func (c *Conn) Send(args ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	if _, err := c.w.WriteString(resp.EncodeBulkStrings(args...)); err != nil {
+		return c.fatal(err)
+	}
+
+	c.pending++
+	return nil
+}
+
+// Flush writes the buffered commands to the server.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return c.fatal(err)
+	}
+
+	return nil
+}
+
+// Receive reads the next reply. Error replies are returned as an Error.
+func (c *Conn) Receive() (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.receive()
+}
+
+func (c *Conn) receive() (any, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	msg, _, err := resp.ParseMessage(c.r, resp.Limits{})
+	if err != nil {
+		return nil, c.fatal(err)
+	}
+
+	if c.pending > 0 {
+		c.pending--
+	}
+
+	reply := decode(msg)
+	if err, ok := reply.(Error); ok {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// Do sends a command and waits for its reply, first draining the replies of
+// any pipelined command.
+func (c *Conn) Do(args ...string) (any, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		reply any
+		err   error
+	)
+	for c.pending > 0 {
+		reply, err = c.receive()
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	return reply, err
+}
+
+// decode turns a message into a Go value: string for simple and bulk
+// strings, int64 for integers, []any for arrays, Error for error replies
+// and nil for null bulk strings and arrays.
+func decode(msg resp.Message) any {
+	switch msg.Type {
+	case resp.TypeArray:
+		arr, ok := msg.Content.([]resp.Message)
+		if !ok {
+			return nil
+		}
+
+		values := make([]any, len(arr))
+		for i, m := range arr {
+			values[i] = decode(m)
+		}
+
+		return values
+	case resp.TypeInteger:
+		n, _ := strconv.ParseInt(msg.Content.(string), 10, 64)
+		return n
+	case resp.TypeError:
+		return Error(msg.Content.(string))
+	}
+
+	if msg.Content == nil {
+		return nil
+	}
+
+	return msg.Content
+}
+
+var errUnexpectedReply = errors.New("client: unexpected reply type")
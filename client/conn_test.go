@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// serve returns a Conn to an in-process server answering each command with
+// handle, over a net.Pipe. The server closes the connection when handle
+// returns an empty reply.
+func serve(t *testing.T, handle func(args []string) string) *Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	// The replies are written by their own goroutine, queued like in a
+	// socket buffer: a net.Pipe write blocks until it is read, which would
+	// otherwise stall pipelined commands.
+	replies := make(chan string, 64)
+	go func() {
+		defer server.Close()
+
+		for reply := range replies {
+			if _, err := io.WriteString(server, reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(replies)
+
+		r := bufio.NewReader(server)
+		for {
+			args, _, err := resp.ParseRequest(r, resp.Limits{})
+			if err != nil {
+				return
+			}
+
+			reply := handle(args)
+			if reply == "" {
+				return
+			}
+
+			replies <- reply
+		}
+	}()
+
+	return NewConn(client)
+}
+
+// echo answers ECHO with its argument and anything else with OK.
+func echo(args []string) string {
+	if len(args) == 2 && args[0] == "ECHO" {
+		return resp.EncodeBulkString(args[1])
+	}
+
+	return resp.EncodeSimpleString("OK")
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  any
+		err   error
+	}{
+		{name: "simple string", reply: "+OK\r\n", want: "OK"},
+		{name: "bulk string", reply: "$5\r\nhello\r\n", want: "hello"},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", want: ""},
+		{name: "null bulk string", reply: "$-1\r\n", want: nil},
+		{name: "integer", reply: ":-42\r\n", want: int64(-42)},
+		{name: "null array", reply: "*-1\r\n", want: nil},
+		{name: "empty array", reply: "*0\r\n", want: []any{}},
+		{
+			name:  "nested array",
+			reply: "*3\r\n$1\r\na\r\n:1\r\n*2\r\n$-1\r\n+b\r\n",
+			want:  []any{"a", int64(1), []any{nil, "b"}},
+		},
+		{name: "error", reply: "-ERR unknown command\r\n", err: Error("ERR unknown command")},
+		{name: "error in an array", reply: "*1\r\n-ERR nested\r\n", want: []any{Error("ERR nested")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, func([]string) string { return tt.reply })
+
+			reply, err := c.Do("CMD")
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if !reflect.DeepEqual(reply, tt.want) {
+				t.Fatalf("got %#v, want %#v", reply, tt.want)
+			}
+
+			if c.Err() != nil {
+				t.Fatalf("connection unusable after the reply: %v", c.Err())
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := serve(t, echo)
+
+	for _, arg := range []string{"a", "b", "c"} {
+		if err := c.Send("ECHO", arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"a", "b"} {
+		if got, err := String(c.Receive()); err != nil || got != want {
+			t.Fatalf("got %q, %v, want %q", got, err, want)
+		}
+	}
+
+	// Do drains the reply of "c" left unread before returning its own.
+	if got, err := String(c.Do("ECHO", "d")); err != nil || got != "d" {
+		t.Fatalf("got %q, %v, want \"d\"", got, err)
+	}
+}
+
+func TestConnectionError(t *testing.T) {
+	c := serve(t, func(args []string) string {
+		if args[0] == "QUIT" {
+			return ""
+		}
+
+		return echo(args)
+	})
+
+	if _, err := c.Do("QUIT"); err == nil {
+		t.Fatal("got no error once the server closed the connection")
+	}
+
+	err := c.Err()
+	if err == nil {
+		t.Fatal("connection still usable after a read error")
+	}
+
+	if _, got := c.Do("ECHO", "a"); !errors.Is(got, err) {
+		t.Fatalf("got error %v, want the first one, %v", got, err)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	c := serve(t, func([]string) string { return "?\r\n" })
+
+	_, err := c.Do("CMD")
+
+	var perr resp.ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want a protocol error", err)
+	}
+
+	if c.Err() == nil {
+		t.Fatal("connection still usable after a protocol error")
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrPoolClosed = errors.New("client: pool closed")
+
+// Pool keeps idle connections around for reuse. Connections are created
+// with Dial whenever no idle one is available.
+type Pool struct {
+	Dial    func() (*Conn, error)
+	MaxIdle int
+
+	mu     sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+func NewPool(addr string, maxIdle int) *Pool {
+	return &Pool{
+		Dial:    func() (*Conn, error) { return Dial(addr) },
+		MaxIdle: maxIdle,
+	}
+}
+
+// Get returns an idle connection or dials a new one. It must be handed back
+// with Put once done.
+func (p *Pool) Get() (*Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	return p.Dial()
+}
+
+// Put returns c to the pool. Broken connections and connections with
+// unread pipelined replies are closed instead of being reused.
+func (p *Pool) Put(c *Conn) {
+	c.mu.Lock()
+	reusable := c.err == nil && c.pending == 0
+	c.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !reusable || p.closed || len(p.idle) >= p.MaxIdle {
+		c.Close()
+		return
+	}
+
+	p.idle = append(p.idle, c)
+}
+
+// Close closes the idle connections, connections in use are closed when
+// they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestPool returns a pool dialing in-process servers answering with
+// echo, along with the number of connections it dialed.
+func newTestPool(t *testing.T, maxIdle int) (*Pool, *int) {
+	dials := 0
+	p := &Pool{
+		Dial: func() (*Conn, error) {
+			dials++
+			return serve(t, echo), nil
+		},
+		MaxIdle: maxIdle,
+	}
+	t.Cleanup(func() { p.Close() })
+
+	return p, &dials
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, dials := newTestPool(t, 1)
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(c)
+
+	again, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != c || *dials != 1 {
+		t.Fatalf("got a new connection after %d dials, want the idle one reused", *dials)
+	}
+
+	// Only MaxIdle connections are kept, the others are closed.
+	other, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(again)
+	p.Put(other)
+
+	if _, err := other.Do("PING"); err == nil {
+		t.Fatal("connection over MaxIdle still open")
+	}
+
+	if got, _ := p.Get(); got != again {
+		t.Fatal("idle connection not reused")
+	}
+}
+
+func TestPoolDiscard(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoil func(c *Conn)
+	}{
+		{
+			name: "unread replies",
+			spoil: func(c *Conn) {
+				c.Send("ECHO", "unread")
+				c.Flush()
+			},
+		},
+		{
+			name: "broken connection",
+			spoil: func(c *Conn) {
+				c.NetConn().Close()
+				c.Do("PING")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, dials := newTestPool(t, 1)
+
+			c, err := p.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.spoil(c)
+			p.Put(c)
+
+			again, err := p.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if again == c || *dials != 2 {
+				t.Fatal("unusable connection reused")
+			}
+		})
+	}
+}
+
+func TestPoolErrors(t *testing.T) {
+	failed := errors.New("connection refused")
+	p := &Pool{Dial: func() (*Conn, error) { return nil, failed }, MaxIdle: 1}
+
+	if _, err := p.Get(); err != failed {
+		t.Fatalf("got error %v, want the dial error", err)
+	}
+
+	p, _ = newTestPool(t, 1)
+	c, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	if _, err := p.Get(); err != ErrPoolClosed {
+		t.Fatalf("got error %v, want ErrPoolClosed", err)
+	}
+
+	// Connections in use are closed once put back in a closed pool.
+	p.Put(c)
+	if _, err := c.Do("PING"); err == nil {
+		t.Fatal("connection put back in a closed pool still open")
+	}
+}
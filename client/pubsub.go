@@ -0,0 +1,104 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Message is a message received on a subscribed channel. Pattern is set
+// for messages matched by PSUBSCRIBE.
+type Message struct {
+	Channel string
+	Pattern string
+	Data    string
+}
+
+// Subscription is the confirmation of a (un)subscribe request. Count is the
+// number of channels the connection is still subscribed to.
+type Subscription struct {
+	Kind    string // subscribe, unsubscribe, psubscribe or punsubscribe
+	Channel string
+	Count   int64
+}
+
+// Pong is the reply to a PING sent on a subscribed connection.
+type Pong struct {
+	Data string
+}
+
+// PubSubConn wraps a Conn dedicated to pub/sub.
+type PubSubConn struct {
+	Conn *Conn
+}
+
+func (p PubSubConn) Subscribe(channels ...string) error {
+	return p.send("SUBSCRIBE", channels...)
+}
+
+func (p PubSubConn) PSubscribe(patterns ...string) error {
+	return p.send("PSUBSCRIBE", patterns...)
+}
+
+func (p PubSubConn) Unsubscribe(channels ...string) error {
+	return p.send("UNSUBSCRIBE", channels...)
+}
+
+func (p PubSubConn) PUnsubscribe(patterns ...string) error {
+	return p.send("PUNSUBSCRIBE", patterns...)
+}
+
+func (p PubSubConn) Ping(data string) error {
+	return p.send("PING", data)
+}
+
+func (p PubSubConn) send(cmd string, args ...string) error {
+	if err := p.Conn.Send(append([]string{cmd}, args...)...); err != nil {
+		return err
+	}
+
+	return p.Conn.Flush()
+}
+
+// Receive blocks until the next push, returned as a Message, Subscription
+// or Pong.
+func (p PubSubConn) Receive() (any, error) {
+	values, err := Values(p.Conn.Receive())
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := Strings(values, nil)
+	if err != nil || len(ss) < 2 {
+		return nil, fmt.Errorf("%w: malformed pub/sub push", errUnexpectedReply)
+	}
+
+	switch kind := strings.ToLower(ss[0]); kind {
+	case "message":
+		if len(ss) != 3 {
+			break
+		}
+
+		return Message{Channel: ss[1], Data: ss[2]}, nil
+	case "pmessage":
+		if len(ss) != 4 {
+			break
+		}
+
+		return Message{Pattern: ss[1], Channel: ss[2], Data: ss[3]}, nil
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe":
+		if len(ss) != 3 {
+			break
+		}
+
+		count, err := Int64(values[2], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return Subscription{Kind: kind, Channel: ss[1], Count: count}, nil
+	case "pong":
+		return Pong{Data: ss[1]}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown pub/sub push %q", errUnexpectedReply, ss[0])
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// pubSubServer confirms each (un)subscription, publishes "hello" on the
+// channels subscribed to and on "news.tech" for the patterns, and answers
+// PING with a pong push.
+func pubSubServer(args []string) string {
+	kind := strings.ToLower(args[0])
+
+	var pushes []string
+	for i, name := range args[1:] {
+		pushes = append(pushes, resp.EncodeArray(resp.EncodeBulkString(kind), resp.EncodeBulkString(name), resp.EncodeInteger(i+1)))
+
+		switch kind {
+		case "subscribe":
+			pushes = append(pushes, resp.EncodeBulkStrings("message", name, "hello"))
+		case "psubscribe":
+			pushes = append(pushes, resp.EncodeBulkStrings("pmessage", name, "news.tech", "hello"))
+		}
+	}
+
+	switch args[0] {
+	case "PING":
+		return resp.EncodeBulkStrings("pong", args[1])
+	case "RAW":
+		return args[1]
+	}
+
+	var reply string
+	for _, push := range pushes {
+		reply += push
+	}
+
+	return reply
+}
+
+func TestPubSub(t *testing.T) {
+	p := PubSubConn{Conn: serve(t, pubSubServer)}
+
+	if err := p.Subscribe("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PSubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Ping("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PUnsubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []any{
+		Subscription{Kind: "subscribe", Channel: "a", Count: 1},
+		Message{Channel: "a", Data: "hello"},
+		Subscription{Kind: "subscribe", Channel: "b", Count: 2},
+		Message{Channel: "b", Data: "hello"},
+		Subscription{Kind: "psubscribe", Channel: "news.*", Count: 1},
+		Message{Pattern: "news.*", Channel: "news.tech", Data: "hello"},
+		Pong{Data: "hi"},
+		Subscription{Kind: "unsubscribe", Channel: "a", Count: 1},
+		Subscription{Kind: "punsubscribe", Channel: "news.*", Count: 1},
+	}
+
+	for i, w := range want {
+		got, err := p.Receive()
+		if err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("push %d: got %#v, want %#v", i, got, w)
+		}
+	}
+}
+
+func TestPubSubMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		push string
+	}{
+		{name: "not an array", push: resp.EncodeSimpleString("OK")},
+		{name: "too short", push: resp.EncodeBulkStrings("message")},
+		{name: "unknown kind", push: resp.EncodeBulkStrings("event", "a", "b")},
+		{name: "message without data", push: resp.EncodeBulkStrings("message", "a")},
+		{name: "pmessage without data", push: resp.EncodeBulkStrings("pmessage", "a.*", "a.b")},
+		{name: "invalid count", push: resp.EncodeBulkStrings("subscribe", "a", "many")},
+		{name: "nested array", push: resp.EncodeArray(resp.EncodeBulkString("message"), resp.EncodeArray(), resp.EncodeBulkString("x"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, pubSubServer)
+			p := PubSubConn{Conn: c}
+
+			if err := c.Send("RAW", tt.push); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := p.Receive(); err == nil {
+				t.Fatalf("got %#v, want an error", got)
+			}
+
+			// A malformed push doesn't break the connection.
+			if err := p.Ping("still there"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := p.Receive(); err != nil || got != (Pong{Data: "still there"}) {
+				t.Fatalf("got %#v, %v, want the pong", got, err)
+			}
+		})
+	}
+}
+
+func TestPubSubErrorReply(t *testing.T) {
+	c := serve(t, func([]string) string {
+		return resp.EncodeError("ERR only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+	})
+	p := PubSubConn{Conn: c}
+
+	if err := p.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var e Error
+	if _, err := p.Receive(); !errors.As(err, &e) {
+		t.Fatalf("got error %v, want the error reply", err)
+	}
+}
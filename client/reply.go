@@ -0,0 +1,129 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNil is returned by the reply helpers for null replies.
+var ErrNil = errors.New("client: nil reply")
+
+// The helpers below convert the result of Do or Receive to a Go type, so
+// they can be used as client.String(conn.Do("GET", "key")).
+
+func String(reply any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	switch reply := reply.(type) {
+	case string:
+		return reply, nil
+	case int64:
+		return strconv.FormatInt(reply, 10), nil
+	case nil:
+		return "", ErrNil
+	}
+
+	return "", fmt.Errorf("%w: %T for String", errUnexpectedReply, reply)
+}
+
+func Int64(reply any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		return reply, nil
+	case string:
+		return strconv.ParseInt(reply, 10, 64)
+	case nil:
+		return 0, ErrNil
+	}
+
+	return 0, fmt.Errorf("%w: %T for Int64", errUnexpectedReply, reply)
+}
+
+func Int(reply any, err error) (int, error) {
+	n, err := Int64(reply, err)
+	return int(n), err
+}
+
+// Bool converts integer replies like the one of EXISTS, as well as "OK".
+func Bool(reply any, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		return reply != 0, nil
+	case string:
+		return reply == "OK" || reply == "1", nil
+	case nil:
+		return false, ErrNil
+	}
+
+	return false, fmt.Errorf("%w: %T for Bool", errUnexpectedReply, reply)
+}
+
+func Values(reply any, err error) ([]any, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply := reply.(type) {
+	case []any:
+		return reply, nil
+	case nil:
+		return nil, ErrNil
+	}
+
+	return nil, fmt.Errorf("%w: %T for Values", errUnexpectedReply, reply)
+}
+
+// Strings converts an array reply, null elements become empty strings.
+func Strings(reply any, err error) ([]string, error) {
+	values, err := Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		s, err := String(v, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		ss[i] = s
+	}
+
+	return ss, nil
+}
+
+// StringMap converts a flat array of field/value pairs, like the reply of
+// CONFIG GET.
+func StringMap(reply any, err error) (map[string]string, error) {
+	ss, err := Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ss)%2 != 0 {
+		return nil, errors.New("client: StringMap expects an even number of elements")
+	}
+
+	m := make(map[string]string, len(ss)/2)
+	for i := 0; i < len(ss); i += 2 {
+		m[ss[i]] = ss[i+1]
+	}
+
+	return m, nil
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// errAny stands for any error in the test tables.
+var errAny = errors.New("any error")
+
+func TestReplyHelpers(t *testing.T) {
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name    string
+		convert func(reply any, err error) (any, error)
+		reply   any
+		err     error
+		want    any
+		wantErr error
+	}{
+		{name: "String of a string", convert: asAny(String), reply: "v", want: "v"},
+		{name: "String of an integer", convert: asAny(String), reply: int64(12), want: "12"},
+		{name: "String of nil", convert: asAny(String), reply: nil, want: "", wantErr: ErrNil},
+		{name: "String of an array", convert: asAny(String), reply: []any{}, want: "", wantErr: errUnexpectedReply},
+		{name: "String of an error", convert: asAny(String), err: failed, want: "", wantErr: failed},
+		{name: "Int64 of an integer", convert: asAny(Int64), reply: int64(-3), want: int64(-3)},
+		{name: "Int64 of a numeric string", convert: asAny(Int64), reply: "42", want: int64(42)},
+		{name: "Int64 of nil", convert: asAny(Int64), reply: nil, want: int64(0), wantErr: ErrNil},
+		{name: "Int of an integer", convert: asAny(Int), reply: int64(7), want: 7},
+		{name: "Bool of 0", convert: asAny(Bool), reply: int64(0), want: false},
+		{name: "Bool of 1", convert: asAny(Bool), reply: int64(1), want: true},
+		{name: "Bool of OK", convert: asAny(Bool), reply: "OK", want: true},
+		{name: "Bool of an array", convert: asAny(Bool), reply: []any{}, want: false, wantErr: errUnexpectedReply},
+		{name: "Values of an array", convert: asAny(Values), reply: []any{"a", int64(1)}, want: []any{"a", int64(1)}},
+		{name: "Values of nil", convert: asAny(Values), reply: nil, want: []any(nil), wantErr: ErrNil},
+		{name: "Values of a string", convert: asAny(Values), reply: "a", want: []any(nil), wantErr: errUnexpectedReply},
+		{
+			name:    "Strings with null elements",
+			convert: asAny(Strings),
+			reply:   []any{"a", nil, int64(3)},
+			want:    []string{"a", "", "3"},
+		},
+		{
+			name:    "Strings with a nested array",
+			convert: asAny(Strings),
+			reply:   []any{"a", []any{}},
+			want:    []string(nil),
+			wantErr: errUnexpectedReply,
+		},
+		{
+			name:    "StringMap of pairs",
+			convert: asAny(StringMap),
+			reply:   []any{"dir", "/tmp", "port", "6379"},
+			want:    map[string]string{"dir": "/tmp", "port": "6379"},
+		},
+		{
+			name:    "StringMap of an odd number of elements",
+			convert: asAny(StringMap),
+			reply:   []any{"dir"},
+			want:    map[string]string(nil),
+			wantErr: errAny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.convert(tt.reply, tt.err)
+			switch {
+			case tt.wantErr == nil && err != nil,
+				tt.wantErr != nil && err == nil,
+				tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// asAny adapts a reply helper to the signature shared by the test table.
+func asAny[T any](convert func(reply any, err error) (T, error)) func(any, error) (any, error) {
+	return func(reply any, err error) (any, error) {
+		return convert(reply, err)
+	}
+}
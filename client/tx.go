@@ -0,0 +1,72 @@
+package client
+
+import "errors"
+
+// ErrTxAborted is returned by Tx when EXEC replies with a null array,
+// because a WATCHed key changed.
+var ErrTxAborted = errors.New("client: transaction aborted")
+
+// Tx queues commands between MULTI and EXEC.
+type Tx struct {
+	conn  *Conn
+	count int
+}
+
+// Send queues a command in the transaction.
+func (tx *Tx) Send(args ...string) error {
+	tx.count++
+	return tx.conn.Send(args...)
+}
+
+// Tx runs fn between MULTI and EXEC in a single round trip and returns the
+// replies of the queued commands. The transaction is discarded when fn
+// returns an error.
+func (c *Conn) Tx(fn func(tx *Tx) error) ([]any, error) {
+	if err := c.Send("MULTI"); err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{conn: c}
+	if err := fn(tx); err != nil {
+		_, _ = c.Do("DISCARD")
+		return nil, err
+	}
+
+	if err := c.Send("EXEC"); err != nil {
+		return nil, err
+	}
+
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+
+	// MULTI and every queued command answer before EXEC does, a queuing
+	// error makes EXEC fail with EXECABORT.
+	var queueErr error
+	for i := 0; i < tx.count+1; i++ {
+		if _, err := c.Receive(); err != nil {
+			if c.Err() != nil {
+				return nil, err
+			}
+
+			if queueErr == nil {
+				queueErr = err
+			}
+		}
+	}
+
+	reply, err := c.Receive()
+	if err != nil {
+		if queueErr != nil {
+			return nil, queueErr
+		}
+
+		return nil, err
+	}
+
+	if reply == nil {
+		return nil, ErrTxAborted
+	}
+
+	return Values(reply, nil)
+}
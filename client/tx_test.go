@@ -0,0 +1,150 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// txServer answers like a server supporting MULTI/EXEC where SET and GET
+// are the only known commands, GET replying with the value of its key.
+// EXEC aborts with a null array when abort is set. The commands received
+// are appended to log.
+func txServer(abort bool, log *[]string) func(args []string) string {
+	var (
+		multi   bool
+		queued  []string
+		invalid bool
+	)
+
+	return func(args []string) string {
+		*log = append(*log, args[0])
+
+		switch args[0] {
+		case "MULTI":
+			multi = true
+			return resp.EncodeSimpleString("OK")
+		case "DISCARD":
+			multi, queued, invalid = false, nil, false
+			return resp.EncodeSimpleString("OK")
+		case "EXEC":
+			replies := queued
+			defer func() { multi, queued, invalid = false, nil, false }()
+
+			if invalid {
+				return resp.EncodeError("EXECABORT Transaction discarded because of previous errors.")
+			}
+			if abort {
+				return resp.EncodeNullArray()
+			}
+
+			return resp.EncodeArray(replies...)
+		}
+
+		var reply string
+		switch {
+		case args[0] == "SET" && len(args) == 3:
+			reply = resp.EncodeSimpleString("OK")
+		case args[0] == "GET" && len(args) == 2:
+			reply = resp.EncodeBulkString("value of " + args[1])
+		default:
+			if multi {
+				invalid = true
+			}
+			return resp.EncodeError("ERR unknown command '" + args[0] + "'")
+		}
+
+		if !multi {
+			return reply
+		}
+
+		queued = append(queued, reply)
+		return resp.EncodeSimpleString("QUEUED")
+	}
+}
+
+func TestTx(t *testing.T) {
+	var log []string
+	c := serve(t, txServer(false, &log))
+
+	replies, err := c.Tx(func(tx *Tx) error {
+		if err := tx.Send("SET", "k", "v"); err != nil {
+			return err
+		}
+
+		return tx.Send("GET", "k")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []any{"OK", "value of k"}; !reflect.DeepEqual(replies, want) {
+		t.Fatalf("got %#v, want %#v", replies, want)
+	}
+
+	// The connection is left in sync for the next command.
+	if got, err := String(c.Do("GET", "other")); err != nil || got != "value of other" {
+		t.Fatalf("got %q, %v after the transaction", got, err)
+	}
+}
+
+func TestTxErrors(t *testing.T) {
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name  string
+		abort bool
+		fn    func(tx *Tx) error
+		err   error
+		log   []string
+	}{
+		{
+			name: "queuing error",
+			fn: func(tx *Tx) error {
+				tx.Send("SET", "k", "v")
+				return tx.Send("INCR", "k")
+			},
+			err: Error("ERR unknown command 'INCR'"),
+			log: []string{"MULTI", "SET", "INCR", "EXEC", "GET"},
+		},
+		{
+			name:  "aborted",
+			abort: true,
+			fn: func(tx *Tx) error {
+				return tx.Send("SET", "k", "v")
+			},
+			err: ErrTxAborted,
+			log: []string{"MULTI", "SET", "EXEC", "GET"},
+		},
+		{
+			name: "discarded",
+			fn: func(tx *Tx) error {
+				tx.Send("SET", "k", "v")
+				return failed
+			},
+			err: failed,
+			log: []string{"MULTI", "SET", "DISCARD", "GET"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			c := serve(t, txServer(tt.abort, &log))
+
+			if replies, err := c.Tx(tt.fn); err != tt.err {
+				t.Fatalf("got %#v, %v, want error %v", replies, err, tt.err)
+			}
+
+			if got, err := String(c.Do("GET", "k")); err != nil || got != "value of k" {
+				t.Fatalf("got %q, %v after the transaction", got, err)
+			}
+
+			if !reflect.DeepEqual(log, tt.log) {
+				t.Fatalf("server got %v, want %v", log, tt.log)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+)
+
+// runCheck reads every file of the AOF and reports what they hold, or
+// where they are corrupt. A truncated last file is only a warning: the
+// server repairs it on load when aof-load-truncated is enabled.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aof-tool check <manifest>")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	m, dir, err := loadManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Println("--- AOF ERROR DETECTED ---")
+		fmt.Println(err)
+		return 1
+	}
+
+	fmt.Printf("[info] Checking AOF manifest %s\n", fs.Arg(0))
+
+	files := m.Files()
+	status := 0
+	for i, f := range files {
+		st, err := readFile(filepath.Join(dir, f.Name), nil, func(aof.Entry) bool { return true })
+		if err != nil {
+			fmt.Printf("--- AOF ERROR DETECTED in %s ---\n", f.Name)
+			fmt.Printf("[offset %d] %v\n", st.End, err)
+			return 1
+		}
+
+		kind := "incr"
+		if f.Type == aof.TypeBase {
+			kind = "base"
+		}
+
+		fmt.Printf("[info] %s file %s, seq %d, %d bytes\n", kind, f.Name, f.Seq, st.Size)
+		if st.Preamble > 0 {
+			fmt.Printf("[info]   RDB preamble of %d bytes, %d keys\n", st.Preamble, st.Keys)
+		}
+		fmt.Printf("[info]   %d commands\n", st.Commands)
+		if st.FirstTS != 0 {
+			fmt.Printf("[info]   timestamps from %s to %s\n", formatTS(st.FirstTS), formatTS(st.LastTS))
+		}
+
+		if st.Truncated {
+			if i < len(files)-1 {
+				fmt.Printf("--- AOF ERROR DETECTED in %s ---\n", f.Name)
+				fmt.Printf("[offset %d] unexpected end of file, only the last file may be truncated\n", st.End)
+				return 1
+			}
+
+			fmt.Printf("[warning] %s ends with an incomplete command, valid up to offset %d (%d bytes to truncate)\n",
+				f.Name, st.End, st.Size-st.End)
+			status = 1
+		}
+	}
+
+	if status == 0 {
+		fmt.Println("AOF is valid")
+	}
+
+	return status
+}
+
+func formatTS(ts int64) string {
+	return fmt.Sprintf("%d (%s)", ts, time.Unix(ts, 0).UTC().Format(time.RFC3339))
+}
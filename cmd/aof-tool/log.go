@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+)
+
+// maxArgLen is the length arguments are cut at in the listing.
+const maxArgLen = 64
+
+// runLog lists the commands of the AOF, one per line: their number n,
+// counting from 1 (-to-command n-1 stops right before them), the file and
+// offset they are at, their timestamp and their arguments.
+func runLog(args []string) int {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	since := fs.Int64("since", 0, "only list the commands from this unix `time`")
+	until := fs.Int64("until", 0, "only list the commands up to this unix `time`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aof-tool log [options] <manifest>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	m, dir, err := loadManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	var n int64
+	for _, f := range m.Files() {
+		_, err := readFile(filepath.Join(dir, f.Name), nil, func(e aof.Entry) bool {
+			n++
+			if (*since > 0 && e.Timestamp < *since) || (*until > 0 && e.Timestamp > *until) {
+				return true
+			}
+
+			ts := "-"
+			if e.Timestamp != 0 {
+				ts = strconv.FormatInt(e.Timestamp, 10)
+			}
+
+			fmt.Fprintf(w, "%d\t%s:%d\t%s\t%s\n", n, f.Name, e.Offset, ts, formatArgs(e.Args))
+			return true
+		})
+		if err != nil {
+			w.Flush()
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.Name, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func formatArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > maxArgLen {
+			arg = arg[:maxArgLen] + "..."
+		}
+		quoted[i] = strconv.Quote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
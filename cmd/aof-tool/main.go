@@ -0,0 +1,174 @@
+// Command aof-tool inspects a multi-part append only file offline, through
+// its manifest: it checks its files, lists the commands they hold with
+// their offsets and timestamps, cuts it at a point in time and recovers
+// the dataset as it was then into an RDB file.
+//
+//	aof-tool check <manifest>
+//	aof-tool log [-since ts] [-until ts] <manifest>
+//	aof-tool truncate [-to-timestamp ts] [-to-command n] -o <dir> <manifest>
+//	aof-tool recover [-to-timestamp ts] [-to-command n] -o <file> <manifest>
+//
+// The server recovers the same way when started on the AOF with
+// --aof-recover-to-timestamp or --aof-recover-to-command, and
+// --aof-recover-output.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+const usage = `usage: aof-tool <command> [options] <manifest>
+
+commands:
+  check     verify every file of the AOF, like redis-check-aof
+  log       list the commands with their position and timestamp
+  truncate  copy the AOF up to a timestamp or a number of commands
+  recover   replay the AOF up to a timestamp or a number of commands into
+            an RDB file
+
+Run aof-tool <command> -h for the options of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) int{
+		"check":    runCheck,
+		"log":      runLog,
+		"truncate": runTruncate,
+		"recover":  runRecover,
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	os.Exit(run(os.Args[2:]))
+}
+
+// loadManifest parses the manifest at path, returning it along with the
+// directory its files are in.
+func loadManifest(path string) (*aof.Manifest, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	m, err := aof.ParseManifest(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	return m, filepath.Dir(path), nil
+}
+
+// fileStats describes what readFile read of an AOF file.
+type fileStats struct {
+	Size      int64
+	Preamble  int64 // size of the RDB preamble, 0 when there is none
+	Keys      int   // in the RDB preamble
+	Commands  int
+	FirstTS   int64
+	LastTS    int64
+	End       int64 // offset the file is valid or was stopped at
+	Stopped   bool  // fn asked to stop
+	Truncated bool  // the file ends in the middle of a command
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// readFile reads the AOF file at path, its RDB preamble included, calling
+// preamble, if not nil, with the dataset it holds, then fn with every
+// command until it returns false, in which case End is the offset of the
+// command it stopped at.
+func readFile(path string, preamble func(data rdb.RDB), fn func(e aof.Entry) bool) (fileStats, error) {
+	var st fileStats
+
+	f, err := os.Open(path)
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return st, err
+	}
+	st.Size = info.Size()
+
+	cr := &countingReader{r: f}
+	br := bufio.NewReader(cr)
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		data, err := rdb.ParseFile(br, rdb.Options{Checksum: true, KeepExpired: true})
+		if err != nil {
+			return st, fmt.Errorf("RDB preamble: %w", err)
+		}
+
+		st.Preamble = cr.n - int64(br.Buffered())
+		for _, db := range data.Databases {
+			st.Keys += len(db.Fields)
+		}
+
+		if preamble != nil {
+			preamble(data)
+		}
+	}
+
+	r := aof.NewReader(br, cr.n-int64(br.Buffered()))
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			st.Truncated = true
+			break
+		}
+
+		if err != nil {
+			st.End = r.Offset()
+			return st, err
+		}
+
+		if !fn(e) {
+			st.Stopped = true
+			st.End = e.Offset
+			return st, nil
+		}
+
+		st.Commands++
+		if e.Timestamp != 0 {
+			if st.FirstTS == 0 {
+				st.FirstTS = e.Timestamp
+			}
+			st.LastTS = e.Timestamp
+		}
+	}
+
+	st.End = r.Offset()
+	return st, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+// runRecover replays the AOF, stopped where runTruncate would cut it, and
+// writes the dataset it results in as an RDB file, what the server does
+// when started with --aof-recover-to-timestamp or --aof-recover-to-command
+// but without one running.
+func runRecover(args []string) int {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	toTimestamp := fs.Int64("to-timestamp", 0, "replay the commands up to this unix `time`")
+	toCommand := fs.Int64("to-command", 0, "replay this `number` of commands")
+	out := fs.String("o", "", "output RDB `file`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aof-tool recover [-to-timestamp ts] [-to-command n] -o <file> <manifest>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *out == "" || (*toTimestamp <= 0 && *toCommand <= 0) {
+		fs.Usage()
+		return 2
+	}
+
+	m, dir, err := loadManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, n, err := replay(m, dir, aof.Point{Timestamp: *toTimestamp, Commands: *toCommand})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := writeRDB(*out, data); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Replayed %d commands, dataset written to %s\n", n, *out)
+	return 0
+}
+
+// replay loads the AOF described by m, its files being in dir, up to
+// until: the dataset of its base, then the commands following it. It
+// returns the dataset along with the number of commands replayed. An
+// incomplete command at the end of a file is ignored, as the server does
+// when recovering.
+func replay(m *aof.Manifest, dir string, until aof.Point) (rdb.RDB, int64, error) {
+	data := rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}}
+
+	var n int64
+	var applyErr error
+	for _, f := range m.Files() {
+		st, err := readFile(filepath.Join(dir, f.Name), func(base rdb.RDB) {
+			if f.Type == aof.TypeBase {
+				data = base
+			}
+		}, func(e aof.Entry) bool {
+			if until.Reached(e, n+1) {
+				return false
+			}
+
+			if applyErr = apply(data.Database(0), e.Args); applyErr != nil {
+				applyErr = fmt.Errorf("%s: offset %d: %w", f.Name, e.Offset, applyErr)
+				return false
+			}
+
+			n++
+			return true
+		})
+		if err != nil {
+			return rdb.RDB{}, 0, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		if applyErr != nil {
+			return rdb.RDB{}, 0, applyErr
+		}
+
+		if st.Stopped {
+			break
+		}
+	}
+
+	return data, n, nil
+}
+
+// apply runs a command read from the AOF on db. SET is the only write
+// command the server logs, its expiry made absolute with PXAT, a relative
+// one being counted from now like the server does when it loads the AOF.
+func apply(db *rdb.Database, args []string) error {
+	if !strings.EqualFold(args[0], "set") {
+		return fmt.Errorf("unknown command '%s'", args[0])
+	}
+
+	if len(args) < 3 {
+		return errors.New("wrong number of arguments for 'set' command")
+	}
+
+	f := rdb.Field{Key: args[1], Type: rdb.FieldTypeString, Value: rdb.StringValue(args[2]), LastAccess: time.Now()}
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) || !f.ExpiredTime.IsZero() {
+			return errors.New("syntax error in 'set' command")
+		}
+
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 {
+			return errors.New("invalid expire time in 'set' command")
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "ex":
+			f.ExpiredTime = time.Now().Add(time.Duration(n) * time.Second)
+		case "px":
+			f.ExpiredTime = time.Now().Add(time.Duration(n) * time.Millisecond)
+		case "exat":
+			f.ExpiredTime = time.Unix(n, 0)
+		case "pxat":
+			f.ExpiredTime = time.UnixMilli(n)
+		default:
+			return errors.New("syntax error in 'set' command")
+		}
+	}
+
+	db.Fields[f.Key] = f
+	return nil
+}
+
+// writeRDB writes data to path, replaced only once the file is complete.
+// The aux fields of the base aren't kept: the recovered file isn't an AOF
+// base and continues no replication stream.
+func writeRDB(path string, data rdb.RDB) error {
+	data.AuxField = map[string]string{
+		rdb.AuxFieldRedisVer:  "7.2.0",
+		rdb.AuxFieldRedisBits: strconv.Itoa(strconv.IntSize),
+		rdb.AuxFieldCtime:     strconv.FormatInt(time.Now().Unix(), 10),
+		rdb.AuxFieldAOFBase:   "0",
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	err = rdb.Write(w, rdb.Options{Compression: true, Checksum: true}, data)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
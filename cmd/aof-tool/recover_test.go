@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// writeAOF writes in a temporary dir an AOF made of a base holding "base"
+// and an incr file of four commands, two logged at 1700000000 and two at
+// 1700000100. It returns the path of its manifest.
+func writeAOF(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	base := rdb.RDB{Databases: []*rdb.Database{{ID: 0, Fields: map[string]rdb.Field{}}}}
+	base.Database(0).Set("base", "0")
+
+	f, err := os.Create(filepath.Join(dir, "appendonly.aof.1.base.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	if err := rdb.Write(w, rdb.Options{Checksum: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	incr := aof.TimestampAnnotation(1700000000) +
+		resp.EncodeBulkStrings("SET", "a", "1") +
+		resp.EncodeBulkStrings("SET", "b", "2") +
+		aof.TimestampAnnotation(1700000100) +
+		resp.EncodeBulkStrings("SET", "a", "3", "PXAT", "32503680000000") +
+		resp.EncodeBulkStrings("SET", "c", "4")
+	if err := os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), []byte(incr), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+	path := filepath.Join(dir, "appendonly.aof.manifest")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// values returns the values of the keys of db.
+func values(db *rdb.Database) map[string]string {
+	values := map[string]string{}
+	for key, field := range db.Fields {
+		values[key] = string(field.Value.(rdb.StringValue))
+	}
+
+	return values
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		until    aof.Point
+		want     map[string]string
+		commands int64
+	}{
+		{
+			name:     "whole file",
+			want:     map[string]string{"base": "0", "a": "3", "b": "2", "c": "4"},
+			commands: 4,
+		},
+		{
+			name:     "up to a command",
+			until:    aof.Point{Commands: 3},
+			want:     map[string]string{"base": "0", "a": "3", "b": "2"},
+			commands: 3,
+		},
+		{
+			name:     "up to a timestamp",
+			until:    aof.Point{Timestamp: 1700000050},
+			want:     map[string]string{"base": "0", "a": "1", "b": "2"},
+			commands: 2,
+		},
+		{
+			name:  "before the first command",
+			until: aof.Point{Timestamp: 1600000000},
+			want:  map[string]string{"base": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, dir, err := loadManifest(writeAOF(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, n, err := replay(m, dir, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := values(data.Database(0)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			if n != tt.commands {
+				t.Fatalf("got %d commands replayed, want %d", n, tt.commands)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		expires time.Time
+		invalid bool
+	}{
+		{name: "no expiry", args: []string{"SET", "k", "v"}},
+		{name: "pxat", args: []string{"set", "k", "v", "pxat", "32503680000000"}, expires: time.UnixMilli(32503680000000)},
+		{name: "exat", args: []string{"SET", "k", "v", "EXAT", "32503680000"}, expires: time.Unix(32503680000, 0)},
+		{name: "unknown command", args: []string{"DEL", "k"}, invalid: true},
+		{name: "missing value", args: []string{"SET", "k"}, invalid: true},
+		{name: "missing expire time", args: []string{"SET", "k", "v", "PX"}, invalid: true},
+		{name: "invalid expire time", args: []string{"SET", "k", "v", "PX", "-1"}, invalid: true},
+		{name: "two expiries", args: []string{"SET", "k", "v", "PX", "1", "EX", "1"}, invalid: true},
+		{name: "unknown option", args: []string{"SET", "k", "v", "NX", "1"}, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &rdb.Database{Fields: map[string]rdb.Field{}}
+			err := apply(db, tt.args)
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, ok := db.Fields["k"]
+			if !ok || f.Value != rdb.StringValue("v") {
+				t.Fatalf("got %+v, want k set to v", db.Fields)
+			}
+
+			if !f.ExpiredTime.Equal(tt.expires) {
+				t.Fatalf("got expiry %v, want %v", f.ExpiredTime, tt.expires)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	manifest := writeAOF(t)
+	out := filepath.Join(t.TempDir(), "recovered.rdb")
+
+	if code := runRecover([]string{"-to-command", "2", "-o", out, manifest}); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := rdb.ParseFile(bufio.NewReader(f), rdb.Options{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"base": "0", "a": "1", "b": "2"}
+	if got := values(data.Database(0)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
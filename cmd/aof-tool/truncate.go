@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/redis-starter-go/aof"
+)
+
+// runTruncate copies the AOF to a new directory, stopped before the first
+// command annotated with a timestamp after -to-timestamp, or after
+// -to-command commands. The server can be started on the copy, or
+// recovering from it, to get the dataset back as it was then.
+func runTruncate(args []string) int {
+	fs := flag.NewFlagSet("truncate", flag.ExitOnError)
+	toTimestamp := fs.Int64("to-timestamp", 0, "keep the commands up to this unix `time`")
+	toCommand := fs.Int64("to-command", 0, "keep this `number` of commands")
+	out := fs.String("o", "", "output `directory`, created if needed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aof-tool truncate [-to-timestamp ts] [-to-command n] -o <dir> <manifest>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *out == "" || (*toTimestamp <= 0 && *toCommand <= 0) {
+		fs.Usage()
+		return 2
+	}
+
+	m, dir, err := loadManifest(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	until := aof.Point{Timestamp: *toTimestamp, Commands: *toCommand}
+	kept := &aof.Manifest{}
+	var n int64
+	for _, f := range m.Files() {
+		src := filepath.Join(dir, f.Name)
+		st, err := readFile(src, nil, func(e aof.Entry) bool {
+			if until.Reached(e, n+1) {
+				return false
+			}
+			n++
+			return true
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.Name, err)
+			return 1
+		}
+
+		if err := copyFile(filepath.Join(*out, f.Name), src, st.End); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if f.Type == aof.TypeBase {
+			base := f
+			kept.Base = &base
+		} else {
+			kept.Incr = append(kept.Incr, f)
+		}
+
+		if st.Stopped {
+			fmt.Printf("Stopped in %s at offset %d\n", f.Name, st.End)
+			break
+		}
+	}
+
+	manifest := filepath.Join(*out, filepath.Base(fs.Arg(0)))
+	if err := os.WriteFile(manifest, []byte(kept.String()), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Kept %d commands, manifest written to %s\n", n, manifest)
+	return 0
+}
+
+// copyFile copies the first size bytes of src to dst.
+func copyFile(dst, src string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(out, in, size); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+// runCheck loads a file and reports what it holds, or where it is corrupt.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rdb-tool check <file>")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	path := fs.Arg(0)
+	fmt.Printf("[offset 0] Checking RDB file %s\n", path)
+
+	data, err := load(path, true)
+	if err != nil {
+		fmt.Println("--- RDB ERROR DETECTED ---")
+
+		var rdbErr *rdb.RDBError
+		if errors.As(err, &rdbErr) {
+			fmt.Printf("[offset %d] %v\n", rdbErr.Offset, rdbErr.Err)
+		} else {
+			fmt.Println(err)
+		}
+
+		return 1
+	}
+
+	fmt.Printf("[info] RDB version %s\n", data.RDBVerNum[:])
+
+	aux := make([]string, 0, len(data.AuxField))
+	for k := range data.AuxField {
+		aux = append(aux, k)
+	}
+	sort.Strings(aux)
+
+	for _, k := range aux {
+		fmt.Printf("[info] AUX FIELD %s = '%s'\n", k, data.AuxField[k])
+	}
+
+	if len(data.Functions) > 0 {
+		fmt.Printf("[info] %d function libraries\n", len(data.Functions))
+	}
+
+	for _, aux := range data.ModuleAux {
+		name, ver := moduleTypeName(aux.TypeID)
+		fmt.Printf("[info] module aux data of %s, version %d\n", name, ver)
+	}
+
+	var keys, expires, expired int
+	now := time.Now()
+	for _, db := range sortedDatabases(data) {
+		var dbExpires, dbExpired int
+		for _, f := range db.Fields {
+			if f.ExpiredTime.IsZero() {
+				continue
+			}
+
+			dbExpires++
+			if !f.ExpiredTime.After(now) {
+				dbExpired++
+			}
+		}
+
+		fmt.Printf("[info] db %d: %d keys, %d expires, %d already expired\n", db.ID, len(db.Fields), dbExpires, dbExpired)
+		keys += len(db.Fields)
+		expires += dbExpires
+		expired += dbExpired
+	}
+
+	fmt.Printf("[info] %d keys read\n", keys)
+	fmt.Printf("[info] %d expires\n", expires)
+	fmt.Printf("[info] %d already expired\n", expired)
+	fmt.Println("\\o/ RDB looks OK! \\o/")
+
+	return 0
+}
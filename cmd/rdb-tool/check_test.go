@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureCheck runs check on path, returning its exit code and output.
+func captureCheck(t *testing.T, path string) (int, string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	code := runCheck([]string{path})
+	w.Close()
+
+	return code, <-out
+}
+
+func TestCheck(t *testing.T) {
+	code, out := captureCheck(t, "testdata/sample.rdb")
+	if code != 0 {
+		t.Fatalf("got exit code %d, output:\n%s", code, out)
+	}
+
+	for _, want := range []string{
+		"[info] AUX FIELD redis-ver = '7.2.4'",
+		"[info] db 0: 5 keys, 1 expires, 0 already expired",
+		"[info] db 3: 1 keys, 0 expires, 0 already expired",
+		"[info] 6 keys read",
+		"RDB looks OK!",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCheckCorrupt(t *testing.T) {
+	tests := []struct {
+		file   string
+		report string
+	}{
+		{file: "testdata/corrupt-lzf.rdb", report: "[offset 36] unexpected EOF"},
+		{file: "testdata/corrupt-hash.rdb", report: "[offset 23] failed to load key \"k\": length 4611686018427387905 out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			code, out := captureCheck(t, tt.file)
+			if code != 1 {
+				t.Fatalf("got exit code %d, output:\n%s", code, out)
+			}
+
+			if !strings.Contains(out, "--- RDB ERROR DETECTED ---\n"+tt.report+"\n") {
+				t.Fatalf("output lacks the report %q:\n%s", tt.report, out)
+			}
+		})
+	}
+}
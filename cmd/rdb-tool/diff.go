@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+// runDiff compares two files key by key. Like diff(1) it exits with 1 when
+// they differ: keys only in the first file are prefixed with -, keys only
+// in the second one with + and changed keys with ~.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	expired := fs.Bool("expired", false, "include keys that already expired")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rdb-tool diff [options] <file a> <file b>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	a, err := load(fs.Arg(0), *expired)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+
+	b, err := load(fs.Arg(1), *expired)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+
+	var added, removed, changed int
+	for _, id := range databaseIDs(a, b) {
+		dbA, dbB := a.Database(id), b.Database(id)
+
+		keys := map[string]struct{}{}
+		for k := range dbA.Fields {
+			keys[k] = struct{}{}
+		}
+		for k := range dbB.Fields {
+			keys[k] = struct{}{}
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			fa, inA := dbA.Fields[key]
+			fb, inB := dbB.Fields[key]
+
+			switch {
+			case !inB:
+				removed++
+				fmt.Printf("- db%d %q (%s)\n", id, key, fa.Type)
+			case !inA:
+				added++
+				fmt.Printf("+ db%d %q (%s)\n", id, key, fb.Type)
+			default:
+				if what := fieldDiff(fa, fb); what != "" {
+					changed++
+					fmt.Printf("~ db%d %q %s\n", id, key, what)
+				}
+			}
+		}
+	}
+
+	if added+removed+changed == 0 {
+		return 0
+	}
+
+	fmt.Printf("%d added, %d removed, %d changed\n", added, removed, changed)
+	return 1
+}
+
+// databaseIDs returns the ids of the non empty databases of a and b.
+func databaseIDs(a, b rdb.RDB) []int {
+	seen := map[int]bool{}
+	var ids []int
+	for _, data := range []rdb.RDB{a, b} {
+		for _, db := range data.Databases {
+			if len(db.Fields) > 0 && !seen[db.ID] {
+				seen[db.ID] = true
+				ids = append(ids, db.ID)
+			}
+		}
+	}
+
+	sort.Ints(ids)
+	return ids
+}
+
+// fieldDiff describes how b differs from a, "" when it doesn't.
+func fieldDiff(a, b rdb.Field) string {
+	if a.Type != b.Type {
+		return fmt.Sprintf("type %s -> %s", a.Type, b.Type)
+	}
+
+	if !reflect.DeepEqual(a.Value, b.Value) {
+		ea, sa := valueSize(a.Value)
+		eb, sb := valueSize(b.Value)
+		return fmt.Sprintf("value changed (%d elements, %d bytes -> %d elements, %d bytes)", ea, sa, eb, sb)
+	}
+
+	if !a.ExpiredTime.Equal(b.ExpiredTime) {
+		return fmt.Sprintf("expiry %s -> %s", formatExpiry(a), formatExpiry(b))
+	}
+
+	return ""
+}
+
+func formatExpiry(f rdb.Field) string {
+	if f.ExpiredTime.IsZero() {
+		return "none"
+	}
+
+	return f.ExpiredTime.UTC().Format("2006-01-02T15:04:05.000Z")
+}
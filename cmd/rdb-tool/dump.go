@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// respChunkSize is the number of elements per command when an aggregate is
+// dumped as RESP, keeping commands well under the protocol limits.
+const respChunkSize = 512
+
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	format := fs.String("format", "json", "output `format`: json, ndjson or resp")
+	dbID := fs.Int("db", -1, "only dump this database")
+	match := fs.String("match", "", "only dump keys matching this glob `pattern`")
+	expired := fs.Bool("expired", false, "include keys that already expired")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rdb-tool dump [options] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	data, err := load(fs.Arg(0), *expired)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	keep := func(db *rdb.Database, key string) bool {
+		if *dbID >= 0 && db.ID != *dbID {
+			return false
+		}
+
+		if *match == "" {
+			return true
+		}
+
+		ok, _ := path.Match(*match, key)
+		return ok
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	switch *format {
+	case "json":
+		err = dumpJSON(w, data, keep)
+	case "ndjson":
+		err = dumpNDJSON(w, data, keep)
+	case "resp":
+		err = dumpRESP(w, data, keep)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+type jsonKey struct {
+	DB       int    `json:"db"`
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	ExpireAt int64  `json:"expire_at,omitempty"` // unix milliseconds
+	Value    any    `json:"value"`
+}
+
+type jsonDatabase struct {
+	ID   int       `json:"id"`
+	Keys []jsonKey `json:"keys"`
+}
+
+type jsonDump struct {
+	Version   string            `json:"version"`
+	Aux       map[string]string `json:"aux"`
+	Functions []string          `json:"functions,omitempty"`
+	Databases []jsonDatabase    `json:"databases"`
+}
+
+func newJSONKey(db *rdb.Database, f rdb.Field) jsonKey {
+	k := jsonKey{
+		DB:    db.ID,
+		Key:   f.Key,
+		Type:  f.Type.String(),
+		Value: jsonValue(f.Value),
+	}
+
+	if !f.ExpiredTime.IsZero() {
+		k.ExpireAt = f.ExpiredTime.UnixMilli()
+	}
+
+	return k
+}
+
+func dumpJSON(w *bufio.Writer, data rdb.RDB, keep func(*rdb.Database, string) bool) error {
+	dump := jsonDump{
+		Version:   string(data.RDBVerNum[:]),
+		Aux:       data.AuxField,
+		Functions: data.Functions,
+		Databases: []jsonDatabase{},
+	}
+
+	for _, db := range sortedDatabases(data) {
+		jdb := jsonDatabase{ID: db.ID, Keys: []jsonKey{}}
+		for _, key := range sortedKeys(db) {
+			if keep(db, key) {
+				jdb.Keys = append(jdb.Keys, newJSONKey(db, db.Fields[key]))
+			}
+		}
+
+		if len(jdb.Keys) > 0 {
+			dump.Databases = append(dump.Databases, jdb)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// dumpNDJSON writes one JSON object per key, suitable for streaming.
+func dumpNDJSON(w *bufio.Writer, data rdb.RDB, keep func(*rdb.Database, string) bool) error {
+	enc := json.NewEncoder(w)
+	for _, db := range sortedDatabases(data) {
+		for _, key := range sortedKeys(db) {
+			if !keep(db, key) {
+				continue
+			}
+
+			if err := enc.Encode(newJSONKey(db, db.Fields[key])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonFloat returns f as a number, or a string for values JSON can't hold.
+func jsonFloat(f float64) any {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+
+	return f
+}
+
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case rdb.StringValue:
+		return string(v)
+	case rdb.ListValue:
+		return []string(v)
+	case rdb.SetValue:
+		members := make([]string, 0, len(v))
+		for m := range v {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		return members
+	case rdb.ZSetValue:
+		type member struct {
+			Member string `json:"member"`
+			Score  any    `json:"score"`
+		}
+
+		members := make([]member, 0, len(v))
+		for _, m := range sortedZSet(v) {
+			members = append(members, member{Member: m, Score: jsonFloat(v[m])})
+		}
+		return members
+	case rdb.HashValue:
+		return map[string]string(v)
+	case *rdb.StreamValue:
+		return jsonStream(v)
+	case rdb.ModuleValue:
+		name, ver := moduleTypeName(v.TypeID)
+		values := make([]any, len(v.Values))
+		for i, value := range v.Values {
+			values[i] = value
+			switch f := value.(type) {
+			case float32:
+				values[i] = jsonFloat(float64(f))
+			case float64:
+				values[i] = jsonFloat(f)
+			}
+		}
+		return map[string]any{"module": name, "encver": ver, "values": values}
+	}
+
+	return nil
+}
+
+func jsonStream(v *rdb.StreamValue) any {
+	entries := make([]map[string]any, len(v.Entries))
+	for i, e := range v.Entries {
+		fields := map[string]string{}
+		for j := 0; j+1 < len(e.Fields); j += 2 {
+			fields[e.Fields[j]] = e.Fields[j+1]
+		}
+		entries[i] = map[string]any{"id": e.ID.String(), "fields": fields}
+	}
+
+	groups := make([]map[string]any, len(v.Groups))
+	for i, g := range v.Groups {
+		pending := make([]map[string]any, len(g.Pending))
+		for j, pe := range g.Pending {
+			pending[j] = map[string]any{
+				"id":             pe.ID.String(),
+				"delivery_time":  pe.DeliveryTime,
+				"delivery_count": pe.DeliveryCount,
+			}
+		}
+
+		consumers := make([]map[string]any, len(g.Consumers))
+		for j, c := range g.Consumers {
+			ids := make([]string, len(c.Pending))
+			for k, id := range c.Pending {
+				ids[k] = id.String()
+			}
+			consumers[j] = map[string]any{
+				"name":        c.Name,
+				"seen_time":   c.SeenTime,
+				"active_time": c.ActiveTime,
+				"pending":     ids,
+			}
+		}
+
+		groups[i] = map[string]any{
+			"name":         g.Name,
+			"last_id":      g.LastID.String(),
+			"entries_read": g.EntriesRead,
+			"pending":      pending,
+			"consumers":    consumers,
+		}
+	}
+
+	return map[string]any{
+		"entries":        entries,
+		"length":         v.Length,
+		"last_id":        v.LastID.String(),
+		"first_id":       v.FirstID.String(),
+		"max_deleted_id": v.MaxDeletedID.String(),
+		"entries_added":  v.EntriesAdded,
+		"groups":         groups,
+	}
+}
+
+// sortedZSet returns the members of a sorted set by score, then member.
+func sortedZSet(v rdb.ZSetValue) []string {
+	members := make([]string, 0, len(v))
+	for m := range v {
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := v[members[i]], v[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+
+	return members
+}
+
+// dumpRESP writes the commands recreating the dataset, to be piped to
+// redis-cli --pipe. Module values can't be recreated and are skipped.
+func dumpRESP(w *bufio.Writer, data rdb.RDB, keep func(*rdb.Database, string) bool) error {
+	emit := func(args ...string) {
+		w.WriteString(resp.EncodeBulkStrings(args...))
+	}
+
+	for _, code := range data.Functions {
+		emit("FUNCTION", "LOAD", "REPLACE", code)
+	}
+
+	for _, db := range sortedDatabases(data) {
+		emit("SELECT", strconv.Itoa(db.ID))
+
+		for _, key := range sortedKeys(db) {
+			if !keep(db, key) {
+				continue
+			}
+
+			f := db.Fields[key]
+			if _, ok := f.Value.(rdb.ModuleValue); ok {
+				fmt.Fprintf(os.Stderr, "skipping module value %q\n", key)
+				continue
+			}
+
+			emit("DEL", key)
+			for _, cmd := range respCommands(key, f.Value) {
+				emit(cmd...)
+			}
+
+			if !f.ExpiredTime.IsZero() {
+				emit("PEXPIREAT", key, strconv.FormatInt(f.ExpiredTime.UnixMilli(), 10))
+			}
+		}
+	}
+
+	return w.Flush()
+}
+
+// respCommands returns the commands creating key with value v.
+func respCommands(key string, v any) [][]string {
+	var cmds [][]string
+
+	// chunked adds cmd key followed by items, respChunkSize per command
+	chunked := func(cmd string, items []string, perElement int) {
+		step := respChunkSize * perElement
+		for i := 0; i < len(items); i += step {
+			end := i + step
+			if end > len(items) {
+				end = len(items)
+			}
+			cmds = append(cmds, append([]string{cmd, key}, items[i:end]...))
+		}
+	}
+
+	formatScore := func(f float64) string {
+		return strconv.FormatFloat(f, 'g', 17, 64)
+	}
+
+	switch v := v.(type) {
+	case rdb.StringValue:
+		cmds = append(cmds, []string{"SET", key, string(v)})
+	case rdb.ListValue:
+		chunked("RPUSH", v, 1)
+	case rdb.SetValue:
+		chunked("SADD", jsonValue(v).([]string), 1)
+	case rdb.ZSetValue:
+		var items []string
+		for _, m := range sortedZSet(v) {
+			items = append(items, formatScore(v[m]), m)
+		}
+		chunked("ZADD", items, 2)
+	case rdb.HashValue:
+		fields := make([]string, 0, len(v))
+		for f := range v {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+
+		var items []string
+		for _, f := range fields {
+			items = append(items, f, v[f])
+		}
+		chunked("HSET", items, 2)
+	case *rdb.StreamValue:
+		for _, e := range v.Entries {
+			cmds = append(cmds, append([]string{"XADD", key, e.ID.String()}, e.Fields...))
+		}
+
+		if len(v.Entries) == 0 {
+			// creates the stream and trims the entry right away
+			cmds = append(cmds, []string{"XADD", key, "MAXLEN", "0", "0-1", "", ""})
+		}
+
+		cmds = append(cmds, []string{"XSETID", key, v.LastID.String(),
+			"ENTRIESADDED", strconv.FormatUint(v.EntriesAdded, 10),
+			"MAXDELETEDID", v.MaxDeletedID.String()})
+
+		for _, g := range v.Groups {
+			cmd := []string{"XGROUP", "CREATE", key, g.Name, g.LastID.String()}
+			if g.EntriesRead >= 0 {
+				cmd = append(cmd, "ENTRIESREAD", strconv.FormatInt(g.EntriesRead, 10))
+			}
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	return cmds
+}
@@ -0,0 +1,102 @@
+// Command rdb-tool inspects RDB files offline: it checks their integrity,
+// dumps their content, prints size statistics and compares two snapshots.
+//
+//	rdb-tool check <file>
+//	rdb-tool dump [-format json|ndjson|resp] [-db n] [-match pattern] [-expired] <file>
+//	rdb-tool stats [-sep :] [-depth 1] [-top 20] <file>
+//	rdb-tool diff [-expired] <file a> <file b>
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+const usage = `usage: rdb-tool <command> [options] <file>...
+
+commands:
+  check  verify an RDB file, like redis-check-rdb
+  dump   print the dataset as json, ndjson or a RESP command stream
+  stats  print key counts and sizes per type and key prefix
+  diff   compare two RDB files key by key
+
+Run rdb-tool <command> -h for the options of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) int{
+		"check": runCheck,
+		"dump":  runDump,
+		"stats": runStats,
+		"diff":  runDiff,
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	os.Exit(run(os.Args[2:]))
+}
+
+// load parses the RDB file at path, checksum included.
+func load(path string, keepExpired bool) (rdb.RDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return rdb.RDB{}, err
+	}
+	defer f.Close()
+
+	return rdb.ParseFile(bufio.NewReader(f), rdb.Options{
+		Checksum:    true,
+		KeepExpired: keepExpired,
+	})
+}
+
+// sortedKeys returns the keys of db in order so the output is stable.
+func sortedKeys(db *rdb.Database) []string {
+	keys := make([]string, 0, len(db.Fields))
+	for k := range db.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sortedDatabases returns the non empty databases by id.
+func sortedDatabases(data rdb.RDB) []*rdb.Database {
+	var dbs []*rdb.Database
+	for _, db := range data.Databases {
+		if len(db.Fields) > 0 {
+			dbs = append(dbs, db)
+		}
+	}
+
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID < dbs[j].ID })
+	return dbs
+}
+
+// moduleTypeName decodes the module type name and encoding version packed
+// in a module type id: 9 characters of 6 bits followed by 10 bits of
+// version.
+func moduleTypeName(id uint64) (string, int) {
+	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	name := make([]byte, 9)
+	for i := len(name) - 1; i >= 0; i-- {
+		name[i] = charset[(id>>(10+6*uint(8-i)))&63]
+	}
+
+	return string(name), int(id & 1023)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codecrafters-io/redis-starter-go/rdb"
+)
+
+// keyUsage accumulates the keys of a group, by type or by prefix.
+type keyUsage struct {
+	Name     string
+	Keys     int
+	Elements int
+	Bytes    int
+}
+
+func (u *keyUsage) add(f rdb.Field) {
+	elements, bytes := valueSize(f.Value)
+	u.Keys++
+	u.Elements += elements
+	u.Bytes += len(f.Key) + bytes
+}
+
+// runStats prints the number of keys, elements and bytes by type, by key
+// prefix and for the largest keys.
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	sep := fs.String("sep", ":", "key prefix `separator`")
+	depth := fs.Int("depth", 1, "number of separated segments making up a prefix")
+	top := fs.Int("top", 20, "number of prefixes and largest keys shown")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rdb-tool stats [options] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	data, err := load(fs.Arg(0), false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	byType := map[string]*keyUsage{}
+	byPrefix := map[string]*keyUsage{}
+	var keys []*keyUsage
+	total := &keyUsage{Name: "total"}
+
+	group := func(m map[string]*keyUsage, name string) *keyUsage {
+		u, ok := m[name]
+		if !ok {
+			u = &keyUsage{Name: name}
+			m[name] = u
+		}
+		return u
+	}
+
+	for _, db := range data.Databases {
+		for _, f := range db.Fields {
+			total.add(f)
+			group(byType, f.Type.String()).add(f)
+			group(byPrefix, keyPrefix(f.Key, *sep, *depth)).add(f)
+
+			k := &keyUsage{Name: fmt.Sprintf("db%d %s (%s)", db.ID, f.Key, f.Type)}
+			k.add(f)
+			keys = append(keys, k)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printUsage(w, "type", sortedUsage(byType, 0), total)
+	printUsage(w, "prefix", sortedUsage(byPrefix, *top), total)
+	printUsage(w, "largest keys", topUsage(keys, *top), total)
+
+	return 0
+}
+
+// keyPrefix returns the first depth segments of key, or the whole key when
+// it has fewer segments.
+func keyPrefix(key, sep string, depth int) string {
+	if sep == "" || depth < 1 {
+		return key
+	}
+
+	parts := strings.SplitN(key, sep, depth+1)
+	if len(parts) <= depth {
+		return key
+	}
+
+	return strings.Join(parts[:depth], sep) + sep + "*"
+}
+
+func sortedUsage(m map[string]*keyUsage, top int) []*keyUsage {
+	list := make([]*keyUsage, 0, len(m))
+	for _, u := range m {
+		list = append(list, u)
+	}
+
+	return topUsage(list, top)
+}
+
+// topUsage sorts list by size and keeps the top entries, all when top is 0.
+func topUsage(list []*keyUsage, top int) []*keyUsage {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	if top > 0 && len(list) > top {
+		list = list[:top]
+	}
+
+	return list
+}
+
+func printUsage(w *tabwriter.Writer, title string, list []*keyUsage, total *keyUsage) {
+	fmt.Fprintf(w, "%s\tkeys\telements\tbytes\t%%bytes\t\n", title)
+	for _, u := range list {
+		share := 0.0
+		if total.Bytes > 0 {
+			share = float64(u.Bytes) * 100 / float64(total.Bytes)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\t\n", u.Name, u.Keys, u.Elements, u.Bytes, share)
+	}
+	fmt.Fprintln(w, "\t\t\t\t\t")
+	w.Flush()
+}
+
+// valueSize returns the number of elements of a value and the size of its
+// payload: the strings it holds plus 8 bytes per number. It doesn't account
+// for the overhead of the server's data structures.
+func valueSize(v any) (int, int) {
+	switch v := v.(type) {
+	case rdb.StringValue:
+		return 1, len(v)
+	case rdb.ListValue:
+		n := 0
+		for _, item := range v {
+			n += len(item)
+		}
+		return len(v), n
+	case rdb.SetValue:
+		n := 0
+		for m := range v {
+			n += len(m)
+		}
+		return len(v), n
+	case rdb.ZSetValue:
+		n := 0
+		for m := range v {
+			n += len(m) + 8
+		}
+		return len(v), n
+	case rdb.HashValue:
+		n := 0
+		for f, value := range v {
+			n += len(f) + len(value)
+		}
+		return len(v), n
+	case *rdb.StreamValue:
+		n := 0
+		for _, e := range v.Entries {
+			n += 16
+			for _, s := range e.Fields {
+				n += len(s)
+			}
+		}
+		return len(v.Entries), n
+	case rdb.ModuleValue:
+		n := 0
+		for _, value := range v.Values {
+			if s, ok := value.(string); ok {
+				n += len(s)
+			} else {
+				n += 8
+			}
+		}
+		return len(v.Values), n
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,33 @@
+package rdb
+
+// crc64 is the CRC-64/Jones variant redis uses for RDB checksums: reflected,
+// zero initial value and no final xor, which hash/crc64 can't express.
+type crc64 uint64
+
+var crc64Table = func() [256]uint64 {
+	const poly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 reflected
+
+	var t [256]uint64
+	for i := range t {
+		c := uint64(i)
+		for j := 0; j < 8; j++ {
+			if c&1 == 1 {
+				c = c>>1 ^ poly
+			} else {
+				c >>= 1
+			}
+		}
+		t[i] = c
+	}
+
+	return t
+}()
+
+func (c crc64) update(p []byte) crc64 {
+	crc := uint64(c)
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+
+	return crc64(crc)
+}
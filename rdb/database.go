@@ -0,0 +1,458 @@
+// Package rdb reads and writes redis RDB files, the point-in-time
+// snapshots of the dataset used for persistence and replication.
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AuxFieldRedisVer     = "redis-ver"
+	AuxFieldRedisBits    = "redis-bits"
+	AuxFieldCtime        = "ctime"
+	AuxFieldUsedMem      = "used-mem"
+	AuxFieldReplStreamDB = "repl-stream-db"
+	AuxFieldReplID       = "repl-id"
+	AuxFieldReplOffset   = "repl-offset"
+	AuxFieldAOFBase      = "aof-base"
+)
+
+const (
+	OPCodeFUNCTION2     = 0xF5
+	OPCodeFUNCTIONPreGA = 0xF6
+	OPCodeMODULEAUX     = 0xF7
+	OPCodeIDLE          = 0xF8
+	OPCodeFREQ          = 0xF9
+	OPCodeAUX           = 0xFA
+	OPCodeRESIZEDB      = 0xFB
+	OPCodeEXPIRETIMEMS  = 0xFC
+	OPCodeEXPIRETIME    = 0xFD
+	OPCodeSELECTDB      = 0xFE
+	OPCodeEOF           = 0xFF
+)
+
+type RDB struct {
+	// Magic
+	MagicString [5]byte // 5 bytes SHOULD BE "REDIS"
+	RDBVerNum   [4]byte // 4 bytes
+
+	// Auxiliary field, every one found in the file is kept
+	AuxField  map[string]string
+	Functions []string // code of the function libraries
+	ModuleAux []ModuleAux
+	Databases []*Database
+}
+
+type Database struct {
+	ID       int
+	ResizeDB struct {
+		HashTableSize   int
+		ExpireHashTable int
+	}
+	Fields map[string]Field
+
+	mu sync.RWMutex
+}
+
+// Set stores a string value, discarding any previous expiry.
+func (db *Database) Set(key string, value string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.Fields[key] = Field{
+		Key:        key,
+		Type:       FieldTypeString,
+		Value:      StringValue(value),
+		LastAccess: time.Now(),
+	}
+}
+
+// SetExpire makes key expire at the given time.
+func (db *Database) SetExpire(key string, at time.Time) {
+	db.mu.Lock()
+	f, ok := db.Fields[key]
+	if ok {
+		f.ExpiredTime = at
+		db.Fields[key] = f
+	}
+	db.mu.Unlock()
+
+	if ok {
+		db.UnsetAfter(time.Until(at), key)
+	}
+}
+
+func (db *Database) Unset(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.Fields, key)
+}
+
+// UnsetAfter removes key once duration elapsed, unless it was given another
+// expiry or overwritten in the meantime.
+func (db *Database) UnsetAfter(duration time.Duration, key string) {
+	time.AfterFunc(duration, func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if f, ok := db.Fields[key]; ok && f.expired() {
+			delete(db.Fields, key)
+		}
+	})
+}
+
+// Get returns the value of a string key, updating its access time.
+func (db *Database) Get(key string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	field, ok := db.Fields[key]
+	if !ok || field.expired() {
+		return "", false
+	}
+
+	if field.Type != FieldTypeString {
+		return "", false
+	}
+
+	field.LastAccess = time.Now()
+	db.Fields[key] = field
+
+	return string(field.Value.(StringValue)), true
+}
+
+// Lookup returns the field of key without counting as an access.
+func (db *Database) Lookup(key string) (Field, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	field, ok := db.Fields[key]
+	if !ok || field.expired() {
+		return Field{}, false
+	}
+
+	return field, true
+}
+
+// Type returns the data type of key, ok being false when it doesn't exist.
+func (db *Database) Type(key string) (FieldType, bool) {
+	field, ok := db.Lookup(key)
+	return field.Type, ok
+}
+
+// ScheduleExpiries starts removing the keys that have an expiry once they
+// expire, as needed after loading the database.
+func (db *Database) ScheduleExpiries() {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for key, f := range db.Fields {
+		if !f.ExpiredTime.IsZero() {
+			db.UnsetAfter(time.Until(f.ExpiredTime), key)
+		}
+	}
+}
+
+// Keys returns the names of the keys that are not expired.
+func (db *Database) Keys() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, len(db.Fields))
+	for k, f := range db.Fields {
+		if !f.expired() {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// Snapshot returns a copy of the database that further writes don't
+// affect. Values are never modified in place so they can be shared.
+func (db *Database) Snapshot() *Database {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	snapshot := &Database{
+		ID:     db.ID,
+		Fields: make(map[string]Field, len(db.Fields)),
+	}
+
+	for k, f := range db.Fields {
+		if !f.expired() {
+			snapshot.Fields[k] = f
+		}
+	}
+
+	return snapshot
+}
+
+type FieldType byte
+
+const (
+	FieldTypeString FieldType = 0
+	FieldTypeList   FieldType = 1
+	FieldTypeSet    FieldType = 2
+	FieldTypeZSet   FieldType = 3
+	FieldTypeHash   FieldType = 4
+	FieldTypeModule FieldType = 7
+	FieldTypeStream FieldType = 15
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case FieldTypeString:
+		return "string"
+	case FieldTypeList:
+		return "list"
+	case FieldTypeSet:
+		return "set"
+	case FieldTypeZSet:
+		return "zset"
+	case FieldTypeHash:
+		return "hash"
+	case FieldTypeModule:
+		return "module"
+	case FieldTypeStream:
+		return "stream"
+	}
+
+	return "none"
+}
+
+type Field struct {
+	Key         string
+	ExpiredTime time.Time
+	Type        FieldType
+	Value       any
+
+	LastAccess time.Time // zero when unknown, see OBJECT IDLETIME
+	Freq       byte      // LFU logarithmic access counter, see OBJECT FREQ
+}
+
+func (f Field) expired() bool {
+	return !f.ExpiredTime.IsZero() && !f.ExpiredTime.After(time.Now())
+}
+
+type StringValue string
+
+// ParseFile loads an RDB file. The header is validated and, unless
+// opts.Checksum is false, so is the CRC64 checksum following the EOF
+// opcode. Errors caused by a corrupt file are an *RDBError holding the
+// offset the problem was found at.
+func ParseFile(br *bufio.Reader, opts Options) (rdb RDB, err error) {
+	r := newRDBReader(br)
+
+	// lengths are checked before anything is allocated for them, a panic
+	// decoding a crafted file still only means it is corrupt
+	defer func() {
+		if p := recover(); p != nil {
+			rdb, err = RDB{}, &RDBError{Offset: r.off, Err: fmt.Errorf("%v", p)}
+		}
+	}()
+
+	rdb, err = parseRDB(r, opts)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return RDB{}, &RDBError{Offset: r.off, Err: err}
+	}
+
+	return rdb, nil
+}
+
+func parseRDB(r *rdbReader, opts Options) (RDB, error) {
+	var rdb RDB
+	rdb.AuxField = map[string]string{}
+
+	if _, err := io.ReadFull(r, rdb.MagicString[:]); err != nil {
+		return RDB{}, err
+	}
+
+	if string(rdb.MagicString[:]) != "REDIS" {
+		return RDB{}, errors.New("wrong signature, not an RDB file")
+	}
+
+	if _, err := io.ReadFull(r, rdb.RDBVerNum[:]); err != nil {
+		return RDB{}, err
+	}
+
+	version, err := strconv.Atoi(string(rdb.RDBVerNum[:]))
+	if err != nil || version < 1 || version > rdbVersionNum {
+		return RDB{}, fmt.Errorf("can't handle RDB format version %q", rdb.RDBVerNum[:])
+	}
+
+	// keys before any SELECTDB opcode belong to database 0, which always
+	// exists even when the file holds none
+	cur := rdb.Database(0)
+
+	// expiry, idle time and frequency precede the key they apply to
+	var next Field
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return RDB{}, err
+		}
+
+		if b == OPCodeEOF {
+			break
+		}
+
+		switch b {
+		case OPCodeAUX:
+			key, value, err := parseAux(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			rdb.AuxField[key] = value
+		case OPCodeSELECTDB:
+			dbID, err := DecodeLength(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			cur = rdb.Database(dbID)
+		case OPCodeRESIZEDB:
+			hashTableSize, err := DecodeLength(r)
+			if err != nil {
+				return RDB{}, err
+			}
+			cur.ResizeDB.HashTableSize = hashTableSize
+
+			expireHashTableSize, err := DecodeLength(r)
+			if err != nil {
+				return RDB{}, err
+			}
+			cur.ResizeDB.ExpireHashTable = expireHashTableSize
+		case OPCodeFUNCTION2:
+			code, err := DecodeString(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			rdb.Functions = append(rdb.Functions, code)
+		case OPCodeFUNCTIONPreGA:
+			return RDB{}, errors.New("pre-GA function format not supported")
+		case OPCodeMODULEAUX:
+			aux, err := decodeModuleAux(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			rdb.ModuleAux = append(rdb.ModuleAux, aux)
+		case OPCodeEXPIRETIME:
+			var data uint32
+			if err := binary.Read(r, binary.LittleEndian, &data); err != nil {
+				return RDB{}, err
+			}
+
+			next.ExpiredTime = time.Unix(int64(data), 0)
+		case OPCodeEXPIRETIMEMS:
+			var data uint64
+			if err := binary.Read(r, binary.LittleEndian, &data); err != nil {
+				return RDB{}, err
+			}
+
+			next.ExpiredTime = time.UnixMilli(int64(data))
+		case OPCodeIDLE:
+			idle, err := decodeLength64(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			next.LastAccess = time.Now().Add(-time.Duration(idle) * time.Second)
+		case OPCodeFREQ:
+			freq, err := r.ReadByte()
+			if err != nil {
+				return RDB{}, err
+			}
+
+			next.Freq = freq
+		default:
+			f := next
+			next = Field{}
+
+			key, err := DecodeString(r)
+			if err != nil {
+				return RDB{}, err
+			}
+
+			f.Key = key
+
+			f.Type, f.Value, err = decodeValue(r, b)
+			if err != nil {
+				return RDB{}, fmt.Errorf("failed to load key %q: %w", key, err)
+			}
+
+			if f.expired() && !opts.KeepExpired {
+				continue
+			}
+
+			cur.Fields[key] = f
+		}
+	}
+
+	// the checksum was only added in version 5, a zero checksum meaning
+	// the file was saved with rdbchecksum disabled
+	if version < 5 {
+		return rdb, nil
+	}
+
+	expected := uint64(r.crc)
+	var checksum [8]byte
+	if _, err := io.ReadFull(r, checksum[:]); err != nil {
+		return RDB{}, err
+	}
+
+	stored := binary.LittleEndian.Uint64(checksum[:])
+	if opts.Checksum && stored != 0 && stored != expected {
+		return RDB{}, fmt.Errorf("wrong RDB checksum, expected %016x got %016x", expected, stored)
+	}
+
+	return rdb, nil
+}
+
+// Database returns the database with the given id, creating it if the RDB
+// doesn't have it yet.
+func (rdb *RDB) Database(id int) *Database {
+	for _, db := range rdb.Databases {
+		if db.ID == id {
+			return db
+		}
+	}
+
+	db := &Database{
+		ID:     id,
+		Fields: map[string]Field{},
+	}
+	rdb.Databases = append(rdb.Databases, db)
+
+	return db
+}
+
+func parseAux(r *rdbReader) (string, string, error) {
+	var kv [2]string
+
+	for i := 0; i < len(kv); i++ {
+		str, err := DecodeString(r)
+		if err != nil {
+			return "", "", err
+		}
+
+		kv[i] = str
+	}
+
+	return kv[0], kv[1], nil
+}
@@ -0,0 +1,67 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// length64 encodes n with the 64 bit length encoding.
+func length64(n uint64) []byte {
+	b := []byte{lengthEnc64}
+	for i := 7; i >= 0; i-- {
+		b = append(b, byte(n>>(8*i)))
+	}
+
+	return b
+}
+
+// craftFile returns an RDB file holding key k of type rdbType, whose value
+// is encoded as value, with no checksum.
+func craftFile(rdbType byte, value ...[]byte) []byte {
+	b := []byte("REDIS" + rdbVersion)
+	b = append(b, rdbType, 1, 'k')
+	for _, v := range value {
+		b = append(b, v...)
+	}
+
+	return append(b, OPCodeEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func TestParseFileCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{name: "truncated", file: []byte("REDIS0011\xfa")},
+		{name: "huge string length", file: craftFile(rdbTypeString, length64(1<<62))},
+		{
+			name: "huge lzf compressed length",
+			file: craftFile(rdbTypeString, []byte{0xc3}, length64(1<<62), []byte{5}),
+		},
+		{
+			name: "huge lzf length",
+			file: craftFile(rdbTypeString, []byte{0xc3, 2}, length64(1<<62), []byte{0, 'a'}),
+		},
+		{name: "huge hash length", file: craftFile(rdbTypeHash, length64(1<<62+1))},
+		{name: "huge list length", file: craftFile(rdbTypeList, length64(1<<62))},
+		{name: "huge zset length", file: craftFile(rdbTypeZSet2, length64(1<<62))},
+		{name: "corrupt intset", file: craftFile(rdbTypeSetIntset, []byte{12, 8, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFile(bufio.NewReader(bytes.NewReader(tt.file)), Options{})
+
+			var rdbErr *RDBError
+			if !errors.As(err, &rdbErr) {
+				t.Fatalf("got error %v, want an *RDBError", err)
+			}
+
+			if rdbErr.Offset <= 0 || rdbErr.Offset > int64(len(tt.file)) {
+				t.Fatalf("got offset %d for a %d bytes file", rdbErr.Offset, len(tt.file))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The two high bits of the first byte select the length encoding:
+//
+//	00xxxxxx            6 bit length
+//	01xxxxxx xxxxxxxx   14 bit length, big endian
+//	10000000 + 4 bytes  32 bit length, big endian
+//	10000001 + 8 bytes  64 bit length, big endian
+//	11xxxxxx            special string encoding, see DecodeString
+const (
+	lengthEnc32 = 0x80
+	lengthEnc64 = 0x81
+)
+
+var errSpecialEncoding = errors.New("special string encoding where a length was expected")
+
+func DecodeLength(r *rdbReader) (int, error) {
+	n, err := decodeLength64(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > math.MaxInt {
+		return 0, fmt.Errorf("length %d out of range", n)
+	}
+
+	return int(n), nil
+}
+
+// decodeLength64 reads a length that may not fit in an int, as used for
+// module type ids, stream IDs and module values.
+func decodeLength64(r *rdbReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch b >> 6 {
+	case 0b00:
+		return uint64(b & 0x3f), nil
+	case 0b01:
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		return uint64(b&0x3f)<<8 | uint64(next), nil
+	case 0b11:
+		return 0, errSpecialEncoding
+	}
+
+	switch b {
+	case lengthEnc32:
+		var bs [4]byte
+		if _, err := io.ReadFull(r, bs[:]); err != nil {
+			return 0, err
+		}
+
+		return uint64(binary.BigEndian.Uint32(bs[:])), nil
+	case lengthEnc64:
+		var bs [8]byte
+		if _, err := io.ReadFull(r, bs[:]); err != nil {
+			return 0, err
+		}
+
+		return binary.BigEndian.Uint64(bs[:]), nil
+	}
+
+	return 0, fmt.Errorf("unknown length encoding 0x%02x", b)
+}
+
+// EncodeLength encodes n with the smallest of the length encodings read by
+// DecodeLength.
+func EncodeLength(n int) []byte {
+	return encodeLength64(uint64(n))
+}
+
+func encodeLength64(n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{0b01000000 | byte(n>>8), byte(n)}
+	case n <= math.MaxUint32:
+		b := []byte{lengthEnc32, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	default:
+		b := []byte{lengthEnc64, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		return b
+	}
+}
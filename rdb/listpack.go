@@ -0,0 +1,211 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Listpacks replaced ziplists in redis 7. Like them they are stored in the
+// RDB as a plain string holding the serialized structure:
+//
+//	<total bytes:u32> <num elements:u16> <element>... <0xFF>
+//	element: <encoding> <data> <backlen>
+//
+// backlen is the size of encoding+data stored in 1 to 5 bytes so the list
+// can be walked backwards.
+
+var errListpackCorrupt = errors.New("corrupt listpack")
+
+// decodeListpack returns the elements of a listpack, integers being
+// formatted as strings.
+func decodeListpack(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, errListpackCorrupt
+	}
+
+	var elements []string
+	p := 6
+	for {
+		if p >= len(b) {
+			return nil, errListpackCorrupt
+		}
+
+		if b[p] == 0xFF {
+			return elements, nil
+		}
+
+		element, n, err := decodeListpackElement(b[p:])
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+		p += n + listpackBacklenSize(n)
+	}
+}
+
+// decodeListpackElement decodes the element at the start of b, returning
+// it with the size of its encoding and data.
+func decodeListpackElement(b []byte) (string, int, error) {
+	need := func(n int) error {
+		if n > len(b) {
+			return errListpackCorrupt
+		}
+		return nil
+	}
+
+	enc := b[0]
+	switch {
+	case enc>>7 == 0: // 7 bit unsigned int
+		return strconv.Itoa(int(enc)), 1, nil
+	case enc>>6 == 0b10: // string, 6 bit length
+		n := int(enc & 0x3f)
+		if err := need(1 + n); err != nil {
+			return "", 0, err
+		}
+		return string(b[1 : 1+n]), 1 + n, nil
+	case enc>>5 == 0b110: // 13 bit signed int
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		v := int(enc&0x1f)<<8 | int(b[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return strconv.Itoa(v), 2, nil
+	case enc>>4 == 0b1110: // string, 12 bit length
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		n := int(enc&0x0f)<<8 | int(b[1])
+		if err := need(2 + n); err != nil {
+			return "", 0, err
+		}
+		return string(b[2 : 2+n]), 2 + n, nil
+	case enc == 0xF0: // string, 32 bit length
+		if err := need(5); err != nil {
+			return "", 0, err
+		}
+		n := int(binary.LittleEndian.Uint32(b[1:]))
+		if err := need(5 + n); err != nil {
+			return "", 0, err
+		}
+		return string(b[5 : 5+n]), 5 + n, nil
+	case enc == 0xF1: // int16
+		if err := need(3); err != nil {
+			return "", 0, err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b[1:])))), 3, nil
+	case enc == 0xF2: // int24
+		if err := need(4); err != nil {
+			return "", 0, err
+		}
+		v := int32(uint32(b[1])<<8|uint32(b[2])<<16|uint32(b[3])<<24) >> 8
+		return strconv.Itoa(int(v)), 4, nil
+	case enc == 0xF3: // int32
+		if err := need(5); err != nil {
+			return "", 0, err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b[1:])))), 5, nil
+	case enc == 0xF4: // int64
+		if err := need(9); err != nil {
+			return "", 0, err
+		}
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b[1:])), 10), 9, nil
+	}
+
+	return "", 0, errListpackCorrupt
+}
+
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+
+	return 5
+}
+
+// encodeListpack serializes elements as a listpack, using the integer
+// encodings for elements that are canonical integers.
+func encodeListpack(elements []string) []byte {
+	b := make([]byte, 6, 64)
+	for _, element := range elements {
+		entry := encodeListpackElement(element)
+		b = append(b, entry...)
+		b = append(b, encodeListpackBacklen(len(entry))...)
+	}
+	b = append(b, 0xFF)
+
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	count := len(elements)
+	if count > 65535 {
+		count = 65535 // unknown, has to be counted by walking the listpack
+	}
+	binary.LittleEndian.PutUint16(b[4:], uint16(count))
+
+	return b
+}
+
+func encodeListpackElement(s string) []byte {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+		switch {
+		case v >= 0 && v <= 127:
+			return []byte{byte(v)}
+		case v >= -4096 && v <= 4095:
+			u := uint16(v) & 0x1fff
+			return []byte{0b11000000 | byte(u>>8), byte(u)}
+		case v >= -1<<15 && v < 1<<15:
+			b := []byte{0xF1, 0, 0}
+			binary.LittleEndian.PutUint16(b[1:], uint16(v))
+			return b
+		case v >= -1<<23 && v < 1<<23:
+			u := uint32(v)
+			return []byte{0xF2, byte(u), byte(u >> 8), byte(u >> 16)}
+		case v >= -1<<31 && v < 1<<31:
+			b := []byte{0xF3, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint32(b[1:], uint32(v))
+			return b
+		default:
+			b := []byte{0xF4, 0, 0, 0, 0, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint64(b[1:], uint64(v))
+			return b
+		}
+	}
+
+	n := len(s)
+	switch {
+	case n < 64:
+		return append([]byte{0b10000000 | byte(n)}, s...)
+	case n < 4096:
+		return append([]byte{0b11100000 | byte(n>>8), byte(n)}, s...)
+	default:
+		b := []byte{0xF0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+		return append(b, s...)
+	}
+}
+
+// encodeListpackBacklen encodes n so that it reads right to left: the
+// last byte holds the lowest 7 bits, every byte but the first one has its
+// high bit set.
+func encodeListpackBacklen(n int) []byte {
+	size := listpackBacklenSize(n)
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(n & 127)
+		if i > 0 {
+			b[i] |= 128
+		}
+		n >>= 7
+	}
+
+	return b
+}
@@ -0,0 +1,173 @@
+package rdb
+
+import "errors"
+
+// LZF is the compression scheme redis uses for strings in RDB files. The
+// format is a sequence of chunks, each starting with a control byte:
+//
+//	000LLLLL                       literal run of L+1 bytes
+//	LLLooooo oooooooo              back reference of L+2 bytes
+//	111ooooo LLLLLLLL oooooooo     back reference of L+9 bytes
+//
+// where o is the distance minus one from the current output position.
+
+const (
+	lzfHashLog     = 14
+	lzfMaxLiteral  = 1 << 5
+	lzfMaxOffset   = 1 << 13
+	lzfMaxRefLen   = (1 << 8) + (1 << 3) // 264
+	lzfMinMatchLen = 3
+
+	// lzfMaxRatio bounds how much LZF can expand its input: a 3 byte back
+	// reference stands for at most lzfMaxRefLen bytes.
+	lzfMaxRatio = (lzfMaxRefLen + 2) / 3
+)
+
+var errLZFCorrupt = errors.New("lzf: corrupt input")
+
+// lzfDecompress decompresses in, which must expand to exactly outLen bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	// checked before allocating for it, outLen coming from the file
+	if outLen < 0 || outLen/lzfMaxRatio > len(in) {
+		return nil, errLZFCorrupt
+	}
+
+	out := make([]byte, 0, outLen)
+
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < lzfMaxLiteral {
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, errLZFCorrupt
+			}
+
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLZFCorrupt
+			}
+
+			length += int(in[ip])
+			ip++
+		}
+		length += 2
+
+		if ip >= len(in) {
+			return nil, errLZFCorrupt
+		}
+
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errLZFCorrupt
+		}
+
+		// byte by byte as the reference may overlap what is being written
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZFCorrupt
+	}
+
+	return out, nil
+}
+
+// lzfCompress compresses in, returning nil when the result wouldn't fit in
+// maxLen bytes.
+func lzfCompress(in []byte, maxLen int) []byte {
+	var htab [1 << lzfHashLog]int // position+1 of the last occurrence of a hash
+
+	hash := func(p int) int {
+		v := uint32(in[p])<<16 | uint32(in[p+1])<<8 | uint32(in[p+2])
+		return int((v * 2654435761) >> (32 - lzfHashLog))
+	}
+
+	out := make([]byte, 1, maxLen+1) // out[0] is the first literal run control byte
+	lit := 0
+
+	// closeLiteral finishes the pending literal run, dropping its control
+	// byte when the run is empty.
+	closeLiteral := func() {
+		if lit == 0 {
+			out = out[:len(out)-1]
+			return
+		}
+
+		out[len(out)-lit-1] = byte(lit - 1)
+	}
+
+	for ip := 0; ip < len(in); {
+		if ip+lzfMinMatchLen <= len(in) {
+			h := hash(ip)
+			ref := htab[h] - 1
+			htab[h] = ip + 1
+
+			off := ip - ref - 1
+			if ref >= 0 && off < lzfMaxOffset &&
+				in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+				maxMatch := len(in) - ip
+				if maxMatch > lzfMaxRefLen {
+					maxMatch = lzfMaxRefLen
+				}
+
+				n := lzfMinMatchLen
+				for n < maxMatch && in[ref+n] == in[ip+n] {
+					n++
+				}
+
+				closeLiteral()
+
+				l := n - 2
+				if l < 7 {
+					out = append(out, byte(l<<5|off>>8))
+				} else {
+					out = append(out, byte(7<<5|off>>8), byte(l-7))
+				}
+				out = append(out, byte(off))
+
+				out = append(out, 0) // next literal run
+				lit = 0
+				ip += n
+
+				if len(out) > maxLen+1 {
+					return nil
+				}
+				continue
+			}
+		}
+
+		out = append(out, in[ip])
+		lit++
+		ip++
+
+		if lit == lzfMaxLiteral {
+			closeLiteral()
+			out = append(out, 0)
+			lit = 0
+		}
+
+		if len(out) > maxLen+1 {
+			return nil
+		}
+	}
+
+	closeLiteral()
+
+	if len(out) > maxLen {
+		return nil
+	}
+
+	return out
+}
@@ -0,0 +1,60 @@
+package rdb
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// rdbReader reads an RDB file while keeping the checksum of everything read
+// and the offset reached, reported when the file turns out to be corrupt.
+type rdbReader struct {
+	r   *bufio.Reader
+	crc crc64
+	off int64
+}
+
+func newRDBReader(r *bufio.Reader) *rdbReader {
+	return &rdbReader{r: r}
+}
+
+func (r *rdbReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc = r.crc.update(p[:n])
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *rdbReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	r.crc = r.crc.update([]byte{b})
+	r.off++
+	return b, nil
+}
+
+// peekByte returns the next byte without consuming it.
+func (r *rdbReader) peekByte() (byte, error) {
+	b, err := r.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// RDBError reports where loading an RDB file failed.
+type RDBError struct {
+	Offset int64 // offset of the byte following the last one read
+	Err    error
+}
+
+func (e *RDBError) Error() string {
+	return fmt.Sprintf("corrupt RDB at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *RDBError) Unwrap() error {
+	return e.Err
+}
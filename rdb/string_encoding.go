@@ -0,0 +1,176 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func DecodeString(r *rdbReader) (string, error) {
+	b, err := r.peekByte()
+	if err != nil {
+		return "", err
+	}
+
+	if b>>6 != 0b11 {
+		return decodeLengthPrefixed(r)
+	}
+
+	if _, err := r.ReadByte(); err != nil {
+		return "", err
+	}
+
+	remainingSixBits := b & 0b00111111
+
+	switch remainingSixBits {
+	case 0:
+		// next bit is an 8 bit integer string
+		i, err := decodeInt(r, 8)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(i), nil
+	case 1:
+		// next 2 bits are an 16 bit integer string
+		i, err := decodeInt(r, 16)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(i), nil
+	case 2:
+		// next 4 bits are an 32 bit integer string
+		i, err := decodeInt(r, 32)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(i), nil
+	case 3:
+		// LZF compressed string
+		return decodeLZF(r)
+	default:
+		return "", fmt.Errorf("unknown string encoding 0x%02x", b)
+	}
+}
+
+func decodeLZF(r *rdbReader) (string, error) {
+	compressedLen, err := DecodeLength(r)
+	if err != nil {
+		return "", err
+	}
+
+	length, err := DecodeLength(r)
+	if err != nil {
+		return "", err
+	}
+
+	// the buffer grows with the data actually read rather than with the
+	// announced length
+	var compressed bytes.Buffer
+	if _, err := io.CopyN(&compressed, r, int64(compressedLen)); err != nil {
+		return "", err
+	}
+
+	b, err := lzfDecompress(compressed.Bytes(), length)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func decodeLengthPrefixed(r *rdbReader) (string, error) {
+	length, err := DecodeLength(r)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if _, err := io.CopyN(&sb, r, int64(length)); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+func decodeInt(r *rdbReader, bitSize int) (int, error) {
+	switch bitSize {
+	case 8, 16, 32:
+	default:
+		return 0, errors.New("unknown bitSize")
+	}
+
+	switch bitSize {
+	case 8:
+		var res int8
+		if err := binary.Read(r, binary.LittleEndian, &res); err != nil {
+			return 0, err
+		}
+
+		return int(res), nil
+	case 16:
+		var res int16
+		if err := binary.Read(r, binary.LittleEndian, &res); err != nil {
+			return 0, err
+		}
+
+		return int(res), nil
+	case 32:
+		var res int32
+		if err := binary.Read(r, binary.LittleEndian, &res); err != nil {
+			return 0, err
+		}
+
+		return int(res), nil
+	}
+
+	return 0, errors.New("unknown bitSize")
+}
+
+// EncodeString encodes s the way DecodeString reads it back, using the
+// integer encodings when s is the canonical form of a small enough integer.
+func EncodeString(s string) []byte {
+	return encodeString(s, false)
+}
+
+// EncodeStringLZF is EncodeString, additionally LZF compressing strings
+// longer than 20 bytes when that saves space.
+func EncodeStringLZF(s string) []byte {
+	return encodeString(s, true)
+}
+
+func encodeString(s string, compress bool) []byte {
+	if i, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(i, 10) == s {
+		switch {
+		case i >= math.MinInt8 && i <= math.MaxInt8:
+			return []byte{0b11000000, byte(int8(i))}
+		case i >= math.MinInt16 && i <= math.MaxInt16:
+			b := []byte{0b11000001, 0, 0}
+			binary.LittleEndian.PutUint16(b[1:], uint16(int16(i)))
+			return b
+		default:
+			b := []byte{0b11000010, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint32(b[1:], uint32(int32(i)))
+			return b
+		}
+	}
+
+	if compress && len(s) > 20 {
+		// like redis, only keep the compressed form if it saves 4 bytes
+		if compressed := lzfCompress([]byte(s), len(s)-4); compressed != nil {
+			b := []byte{0b11000011}
+			b = append(b, EncodeLength(len(compressed))...)
+			b = append(b, EncodeLength(len(s))...)
+			return append(b, compressed...)
+		}
+	}
+
+	return append(EncodeLength(len(s)), s...)
+}
@@ -0,0 +1,782 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Value types as stored in RDB files. Several encodings exist for most data
+// types, they are decoded into the value types below and the Field keeps
+// the data type (FieldTypeString, FieldTypeList...) rather than the
+// encoding it was read from.
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZSet             = 3
+	rdbTypeHash             = 4
+	rdbTypeZSet2            = 5
+	rdbTypeModulePreGA      = 6
+	rdbTypeModule2          = 7
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZSetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZSetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+)
+
+type ListValue []string
+
+type SetValue map[string]struct{}
+
+type ZSetValue map[string]float64
+
+type HashValue map[string]string
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string // field, value, field, value...
+}
+
+type StreamPendingEntry struct {
+	ID            StreamID
+	DeliveryTime  int64 // unix milliseconds
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64 // unix milliseconds
+	ActiveTime int64 // unix milliseconds, -1 when unknown
+	Pending    []StreamID
+}
+
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64 // -1 when unknown
+	Pending     []StreamPendingEntry
+	Consumers   []StreamConsumer
+}
+
+type StreamValue struct {
+	Entries      []StreamEntry
+	Length       uint64
+	LastID       StreamID
+	FirstID      StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []StreamGroup
+}
+
+// ModuleValue is the opaque serialization of a module data type, kept so
+// it can be written back. Values holds what the module saved, as int64,
+// uint64, float32, float64 or string.
+type ModuleValue struct {
+	TypeID uint64
+	Values []any
+}
+
+// ModuleAux is module data saved outside of any key, before or after the
+// keyspace depending on When.
+type ModuleAux struct {
+	TypeID uint64
+	When   uint64
+	Values []any
+}
+
+// module value opcodes of RDB_TYPE_MODULE_2
+const (
+	rdbModuleOpcodeEOF    = 0
+	rdbModuleOpcodeSInt   = 1
+	rdbModuleOpcodeUInt   = 2
+	rdbModuleOpcodeFloat  = 3
+	rdbModuleOpcodeDouble = 4
+	rdbModuleOpcodeString = 5
+)
+
+// stream listpack entry flags
+const (
+	streamItemFlagDeleted    = 1 << 0
+	streamItemFlagSameFields = 1 << 1
+)
+
+// decodeValue reads a value stored with the given RDB type and returns it
+// along with its data type.
+func decodeValue(r *rdbReader, rdbType byte) (FieldType, any, error) {
+	switch rdbType {
+	case rdbTypeString:
+		s, err := DecodeString(r)
+		return FieldTypeString, StringValue(s), err
+	case rdbTypeList:
+		items, err := decodeStrings(r, 1)
+		return FieldTypeList, ListValue(items), err
+	case rdbTypeSet:
+		members, err := decodeStrings(r, 1)
+		return FieldTypeSet, newSetValue(members), err
+	case rdbTypeZSet, rdbTypeZSet2:
+		zset, err := decodeZSet(r, rdbType == rdbTypeZSet2)
+		return FieldTypeZSet, zset, err
+	case rdbTypeHash:
+		pairs, err := decodeStrings(r, 2)
+		return FieldTypeHash, newHashValue(pairs), err
+	case rdbTypeModulePreGA:
+		return 0, nil, errors.New("pre-GA module values can't be loaded")
+	case rdbTypeModule2:
+		v, err := decodeModule(r)
+		return FieldTypeModule, v, err
+	case rdbTypeHashZipmap:
+		pairs, err := decodeEncodedString(r, decodeZipmap)
+		return FieldTypeHash, newHashValue(pairs), err
+	case rdbTypeListZiplist:
+		items, err := decodeEncodedString(r, decodeZiplist)
+		return FieldTypeList, ListValue(items), err
+	case rdbTypeSetIntset:
+		members, err := decodeEncodedString(r, decodeIntset)
+		return FieldTypeSet, newSetValue(members), err
+	case rdbTypeSetListpack:
+		members, err := decodeEncodedString(r, decodeListpack)
+		return FieldTypeSet, newSetValue(members), err
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		decode := decodeZiplist
+		if rdbType == rdbTypeZSetListpack {
+			decode = decodeListpack
+		}
+
+		pairs, err := decodeEncodedString(r, decode)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		zset, err := newZSetValue(pairs)
+		return FieldTypeZSet, zset, err
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		decode := decodeZiplist
+		if rdbType == rdbTypeHashListpack {
+			decode = decodeListpack
+		}
+
+		pairs, err := decodeEncodedString(r, decode)
+		return FieldTypeHash, newHashValue(pairs), err
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		items, err := decodeQuicklist(r, rdbType == rdbTypeListQuicklist2)
+		return FieldTypeList, ListValue(items), err
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		stream, err := decodeStream(r, rdbType)
+		return FieldTypeStream, stream, err
+	}
+
+	return 0, nil, fmt.Errorf("unknown value type %d", rdbType)
+}
+
+// decodeStrings reads a length followed by length*perItem strings.
+func decodeStrings(r *rdbReader, perItem int) ([]string, error) {
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > math.MaxInt/perItem {
+		return nil, fmt.Errorf("length %d out of range", n)
+	}
+
+	ss := make([]string, 0, minInt(n*perItem, 1024))
+	for i := 0; i < n*perItem; i++ {
+		s, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss, nil
+}
+
+// decodeEncodedString reads a string holding a serialized compact encoding
+// and decodes it.
+func decodeEncodedString(r *rdbReader, decode func([]byte) ([]string, error)) ([]string, error) {
+	s, err := DecodeString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode([]byte(s))
+}
+
+func newSetValue(members []string) SetValue {
+	set := make(SetValue, len(members))
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+
+	return set
+}
+
+func newHashValue(pairs []string) HashValue {
+	hash := make(HashValue, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+
+	return hash
+}
+
+// newZSetValue builds a sorted set from member, score pairs.
+func newZSetValue(pairs []string) (ZSetValue, error) {
+	zset := make(ZSetValue, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set score %q", pairs[i+1])
+		}
+
+		zset[pairs[i]] = score
+	}
+
+	return zset, nil
+}
+
+func decodeZSet(r *rdbReader, binaryScores bool) (ZSetValue, error) {
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	zset := make(ZSetValue, minInt(n, 1024))
+	for i := 0; i < n; i++ {
+		member, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if binaryScores {
+			err = binary.Read(r, binary.LittleEndian, &score)
+		} else {
+			score, err = decodeDoubleString(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		zset[member] = score
+	}
+
+	return zset, nil
+}
+
+// decodeDoubleString reads a score of the original sorted set encoding: a
+// length byte followed by its text, 253 to 255 standing for nan, +inf and
+// -inf.
+func decodeDoubleString(r *rdbReader) (float64, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// decodeQuicklist reads a list stored as a sequence of ziplists or, for
+// quicklist2, of listpacks and plain elements.
+func decodeQuicklist(r *rdbReader, v2 bool) ([]string, error) {
+	const (
+		containerPlain  = 1
+		containerPacked = 2
+	)
+
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for i := 0; i < n; i++ {
+		container := containerPacked
+		if v2 {
+			if container, err = DecodeLength(r); err != nil {
+				return nil, err
+			}
+		}
+
+		s, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case container == containerPlain:
+			items = append(items, s)
+		case container == containerPacked && v2:
+			elements, err := decodeListpack([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, elements...)
+		case container == containerPacked:
+			elements, err := decodeZiplist([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, elements...)
+		default:
+			return nil, fmt.Errorf("unknown quicklist container %d", container)
+		}
+	}
+
+	return items, nil
+}
+
+func decodeModule(r *rdbReader) (ModuleValue, error) {
+	id, err := decodeLength64(r)
+	if err != nil {
+		return ModuleValue{}, err
+	}
+
+	values, err := decodeModuleValues(r)
+	if err != nil {
+		return ModuleValue{}, err
+	}
+
+	return ModuleValue{TypeID: id, Values: values}, nil
+}
+
+// decodeModuleAux reads the payload of a MODULE_AUX opcode: the module
+// type id, the when opcode (always a length) and when, then the values.
+func decodeModuleAux(r *rdbReader) (ModuleAux, error) {
+	id, err := decodeLength64(r)
+	if err != nil {
+		return ModuleAux{}, err
+	}
+
+	if _, err := decodeLength64(r); err != nil { // when opcode
+		return ModuleAux{}, err
+	}
+
+	when, err := decodeLength64(r)
+	if err != nil {
+		return ModuleAux{}, err
+	}
+
+	values, err := decodeModuleValues(r)
+	if err != nil {
+		return ModuleAux{}, err
+	}
+
+	return ModuleAux{TypeID: id, When: when, Values: values}, nil
+}
+
+// decodeModuleValues reads opcode prefixed module values up to the EOF
+// opcode.
+func decodeModuleValues(r *rdbReader) ([]any, error) {
+	var values []any
+	for {
+		opcode, err := DecodeLength(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case rdbModuleOpcodeEOF:
+			return values, nil
+		case rdbModuleOpcodeSInt:
+			n, err := decodeLength64(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, int64(n))
+		case rdbModuleOpcodeUInt:
+			n, err := decodeLength64(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, n)
+		case rdbModuleOpcodeFloat:
+			var f float32
+			if err := binary.Read(r, binary.LittleEndian, &f); err != nil {
+				return nil, err
+			}
+			values = append(values, f)
+		case rdbModuleOpcodeDouble:
+			var f float64
+			if err := binary.Read(r, binary.LittleEndian, &f); err != nil {
+				return nil, err
+			}
+			values = append(values, f)
+		case rdbModuleOpcodeString:
+			s, err := DecodeString(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		default:
+			return nil, fmt.Errorf("unknown module opcode %d", opcode)
+		}
+	}
+}
+
+func decodeStreamID(r *rdbReader) (StreamID, error) {
+	ms, err := decodeLength64(r)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	seq, err := decodeLength64(r)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// decodeRawStreamID reads an ID stored as 16 big endian bytes.
+func decodeRawStreamID(r *rdbReader) (StreamID, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return StreamID{}, err
+	}
+
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(b[:8]),
+		Seq: binary.BigEndian.Uint64(b[8:]),
+	}, nil
+}
+
+func decodeMillisecondTime(r *rdbReader) (int64, error) {
+	var ms int64
+	err := binary.Read(r, binary.LittleEndian, &ms)
+	return ms, err
+}
+
+func decodeStream(r *rdbReader, rdbType byte) (*StreamValue, error) {
+	stream := &StreamValue{}
+
+	nodes, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < nodes; i++ {
+		key, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(key) != 16 {
+			return nil, errors.New("stream node key is not a 128 bit ID")
+		}
+
+		master := StreamID{
+			Ms:  binary.BigEndian.Uint64([]byte(key[:8])),
+			Seq: binary.BigEndian.Uint64([]byte(key[8:])),
+		}
+
+		lp, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		elements, err := decodeListpack([]byte(lp))
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := decodeStreamNode(master, elements)
+		if err != nil {
+			return nil, err
+		}
+
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	if stream.Length, err = decodeLength64(r); err != nil {
+		return nil, err
+	}
+
+	if stream.LastID, err = decodeStreamID(r); err != nil {
+		return nil, err
+	}
+
+	if rdbType >= rdbTypeStreamListpacks2 {
+		if stream.FirstID, err = decodeStreamID(r); err != nil {
+			return nil, err
+		}
+
+		if stream.MaxDeletedID, err = decodeStreamID(r); err != nil {
+			return nil, err
+		}
+
+		if stream.EntriesAdded, err = decodeLength64(r); err != nil {
+			return nil, err
+		}
+	} else {
+		stream.EntriesAdded = stream.Length
+		if len(stream.Entries) > 0 {
+			stream.FirstID = stream.Entries[0].ID
+		}
+	}
+
+	groups, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < groups; i++ {
+		group, err := decodeStreamGroup(r, rdbType)
+		if err != nil {
+			return nil, err
+		}
+
+		stream.Groups = append(stream.Groups, group)
+	}
+
+	return stream, nil
+}
+
+// decodeStreamNode decodes the entries of a stream listpack node. The node
+// starts with a master entry: count, deleted count, the number of master
+// fields, the master fields and a 0 terminator. Each entry then holds its
+// flags, its ID as a difference to the master ID, either its values only
+// (when it has the master fields) or its field count and field/value
+// pairs, and finally its number of listpack elements.
+func decodeStreamNode(master StreamID, elements []string) ([]StreamEntry, error) {
+	errCorrupt := errors.New("corrupt stream node")
+
+	p := 0
+	next := func() (string, error) {
+		if p >= len(elements) {
+			return "", errCorrupt
+		}
+		p++
+		return elements[p-1], nil
+	}
+
+	nextInt := func() (int64, error) {
+		s, err := next()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, errCorrupt
+		}
+
+		return n, nil
+	}
+
+	// master entry
+	if _, err := nextInt(); err != nil { // count
+		return nil, err
+	}
+
+	if _, err := nextInt(); err != nil { // deleted
+		return nil, err
+	}
+
+	numMasterFields, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+
+	if numMasterFields < 0 || numMasterFields > int64(len(elements)-p) {
+		return nil, errCorrupt
+	}
+
+	masterFields := make([]string, numMasterFields)
+	for i := range masterFields {
+		if masterFields[i], err = next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := nextInt(); err != nil { // master entry terminator
+		return nil, err
+	}
+
+	var entries []StreamEntry
+	for p < len(elements) {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := StreamEntry{ID: StreamID{
+			Ms:  master.Ms + uint64(msDiff),
+			Seq: master.Seq + uint64(seqDiff),
+		}}
+
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, field, value)
+			}
+		} else {
+			numFields, err := nextInt()
+			if err != nil {
+				return nil, err
+			}
+
+			for i := int64(0); i < numFields*2; i++ {
+				s, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, s)
+			}
+		}
+
+		if _, err := nextInt(); err != nil { // lp-count
+			return nil, err
+		}
+
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func decodeStreamGroup(r *rdbReader, rdbType byte) (StreamGroup, error) {
+	var (
+		group StreamGroup
+		err   error
+	)
+
+	if group.Name, err = DecodeString(r); err != nil {
+		return group, err
+	}
+
+	if group.LastID, err = decodeStreamID(r); err != nil {
+		return group, err
+	}
+
+	group.EntriesRead = -1
+	if rdbType >= rdbTypeStreamListpacks2 {
+		n, err := decodeLength64(r)
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(n)
+	}
+
+	pending, err := DecodeLength(r)
+	if err != nil {
+		return group, err
+	}
+
+	for i := 0; i < pending; i++ {
+		var pe StreamPendingEntry
+		if pe.ID, err = decodeRawStreamID(r); err != nil {
+			return group, err
+		}
+
+		if pe.DeliveryTime, err = decodeMillisecondTime(r); err != nil {
+			return group, err
+		}
+
+		if pe.DeliveryCount, err = decodeLength64(r); err != nil {
+			return group, err
+		}
+
+		group.Pending = append(group.Pending, pe)
+	}
+
+	consumers, err := DecodeLength(r)
+	if err != nil {
+		return group, err
+	}
+
+	for i := 0; i < consumers; i++ {
+		var c StreamConsumer
+		if c.Name, err = DecodeString(r); err != nil {
+			return group, err
+		}
+
+		if c.SeenTime, err = decodeMillisecondTime(r); err != nil {
+			return group, err
+		}
+
+		c.ActiveTime = -1
+		if rdbType >= rdbTypeStreamListpacks3 {
+			if c.ActiveTime, err = decodeMillisecondTime(r); err != nil {
+				return group, err
+			}
+		}
+
+		n, err := DecodeLength(r)
+		if err != nil {
+			return group, err
+		}
+
+		for j := 0; j < n; j++ {
+			id, err := decodeRawStreamID(r)
+			if err != nil {
+				return group, err
+			}
+			c.Pending = append(c.Pending, id)
+		}
+
+		group.Consumers = append(group.Consumers, c)
+	}
+
+	return group, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,352 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rdbVersion is the RDB format version written by Write, and the
+// latest one ParseFile reads.
+const (
+	rdbVersion    = "0011"
+	rdbVersionNum = 11
+)
+
+// Options tunes how Write encodes the dataset.
+type Options struct {
+	Compression bool // LZF compress long strings, rdbcompression
+	Checksum    bool // write and verify the CRC64 checksum, rdbchecksum
+	KeepExpired bool // load keys that already expired instead of skipping them
+}
+
+// rdbWriter writes to w while keeping the checksum of everything written.
+// The first error is kept and makes further writes no-ops.
+type rdbWriter struct {
+	w    io.Writer
+	opts Options
+	crc  crc64
+	err  error
+}
+
+func (w *rdbWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+
+	w.crc = w.crc.update(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *rdbWriter) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *rdbWriter) writeString(s string) {
+	if w.opts.Compression {
+		w.write(EncodeStringLZF(s))
+		return
+	}
+
+	w.write(EncodeString(s))
+}
+
+// Write writes rdb in the RDB format: its aux fields, function
+// libraries, module aux data and databases, followed by the CRC64 checksum
+// of the file. Empty databases are skipped and so are keys that already
+// expired.
+func Write(w io.Writer, opts Options, rdb RDB) error {
+	rw := &rdbWriter{w: w, opts: opts}
+
+	rw.write([]byte("REDIS" + rdbVersion))
+
+	keys := make([]string, 0, len(rdb.AuxField))
+	for k := range rdb.AuxField {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		rw.writeByte(OPCodeAUX)
+		rw.writeString(k)
+		rw.writeString(rdb.AuxField[k])
+	}
+
+	writeModuleAux(rw, rdb.ModuleAux, moduleAuxBeforeRDB)
+
+	for _, code := range rdb.Functions {
+		rw.writeByte(OPCodeFUNCTION2)
+		rw.writeString(code)
+	}
+
+	for _, db := range rdb.Databases {
+		writeDatabase(rw, db)
+	}
+
+	writeModuleAux(rw, rdb.ModuleAux, moduleAuxAfterRDB)
+
+	rw.writeByte(OPCodeEOF)
+
+	checksum := make([]byte, 8)
+	if opts.Checksum {
+		binary.LittleEndian.PutUint64(checksum, uint64(rw.crc))
+	}
+	rw.write(checksum)
+
+	return rw.err
+}
+
+// when a module aux data is saved, REDISMODULE_AUX_BEFORE_RDB and
+// REDISMODULE_AUX_AFTER_RDB
+const (
+	moduleAuxBeforeRDB = 1 << 0
+	moduleAuxAfterRDB  = 1 << 1
+)
+
+func writeModuleAux(rw *rdbWriter, auxes []ModuleAux, when uint64) {
+	for _, aux := range auxes {
+		if aux.When&when == 0 {
+			continue
+		}
+
+		rw.writeByte(OPCodeMODULEAUX)
+		rw.write(encodeLength64(aux.TypeID))
+		rw.write(EncodeLength(rdbModuleOpcodeUInt))
+		rw.write(encodeLength64(aux.When))
+		writeModuleValues(rw, aux.Values)
+	}
+}
+
+func writeDatabase(rw *rdbWriter, db *Database) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var size, expires int
+	for _, f := range db.Fields {
+		if f.expired() {
+			continue
+		}
+
+		size++
+		if !f.ExpiredTime.IsZero() {
+			expires++
+		}
+	}
+
+	if size == 0 {
+		return
+	}
+
+	rw.writeByte(OPCodeSELECTDB)
+	rw.write(EncodeLength(db.ID))
+
+	rw.writeByte(OPCodeRESIZEDB)
+	rw.write(EncodeLength(size))
+	rw.write(EncodeLength(expires))
+
+	for _, f := range db.Fields {
+		if f.expired() {
+			continue
+		}
+
+		writeField(rw, f)
+	}
+}
+
+func writeField(rw *rdbWriter, f Field) {
+	if !f.ExpiredTime.IsZero() {
+		ms := make([]byte, 8)
+		binary.LittleEndian.PutUint64(ms, uint64(f.ExpiredTime.UnixMilli()))
+		rw.writeByte(OPCodeEXPIRETIMEMS)
+		rw.write(ms)
+	}
+
+	if !f.LastAccess.IsZero() {
+		idle := time.Since(f.LastAccess) / time.Second
+		if idle < 0 {
+			idle = 0
+		}
+		rw.writeByte(OPCodeIDLE)
+		rw.write(encodeLength64(uint64(idle)))
+	}
+
+	if f.Freq != 0 {
+		rw.writeByte(OPCodeFREQ)
+		rw.writeByte(f.Freq)
+	}
+
+	switch v := f.Value.(type) {
+	case StringValue:
+		rw.writeByte(rdbTypeString)
+		rw.writeString(f.Key)
+		rw.writeString(string(v))
+	case ListValue:
+		rw.writeByte(rdbTypeList)
+		rw.writeString(f.Key)
+		rw.write(EncodeLength(len(v)))
+		for _, item := range v {
+			rw.writeString(item)
+		}
+	case SetValue:
+		rw.writeByte(rdbTypeSet)
+		rw.writeString(f.Key)
+		rw.write(EncodeLength(len(v)))
+		for member := range v {
+			rw.writeString(member)
+		}
+	case ZSetValue:
+		rw.writeByte(rdbTypeZSet2)
+		rw.writeString(f.Key)
+		rw.write(EncodeLength(len(v)))
+		for member, score := range v {
+			rw.writeString(member)
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(score))
+			rw.write(b)
+		}
+	case HashValue:
+		rw.writeByte(rdbTypeHash)
+		rw.writeString(f.Key)
+		rw.write(EncodeLength(len(v)))
+		for field, value := range v {
+			rw.writeString(field)
+			rw.writeString(value)
+		}
+	case *StreamValue:
+		rw.writeByte(rdbTypeStreamListpacks3)
+		rw.writeString(f.Key)
+		writeStream(rw, v)
+	case ModuleValue:
+		rw.writeByte(rdbTypeModule2)
+		rw.writeString(f.Key)
+		writeModule(rw, v)
+	}
+}
+
+// streamNodeMaxEntries is the number of entries written per stream
+// listpack node, the stream-node-max-entries default.
+const streamNodeMaxEntries = 100
+
+// writeStream writes a stream as RDB_TYPE_STREAM_LISTPACKS_3. Entries are
+// never written with the master fields flag, every entry carrying its own
+// fields.
+func writeStream(rw *rdbWriter, stream *StreamValue) {
+	nodes := (len(stream.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	rw.write(EncodeLength(nodes))
+
+	for i := 0; i < len(stream.Entries); i += streamNodeMaxEntries {
+		entries := stream.Entries[i:minInt(i+streamNodeMaxEntries, len(stream.Entries))]
+		master := entries[0].ID
+
+		rw.writeString(string(encodeRawStreamID(master)))
+		rw.writeString(string(encodeListpack(streamNodeElements(master, entries))))
+	}
+
+	rw.write(encodeLength64(stream.Length))
+	writeStreamID(rw, stream.LastID)
+	writeStreamID(rw, stream.FirstID)
+	writeStreamID(rw, stream.MaxDeletedID)
+	rw.write(encodeLength64(stream.EntriesAdded))
+
+	rw.write(EncodeLength(len(stream.Groups)))
+	for _, group := range stream.Groups {
+		rw.writeString(group.Name)
+		writeStreamID(rw, group.LastID)
+		rw.write(encodeLength64(uint64(group.EntriesRead))) // -1 when unknown
+
+		rw.write(EncodeLength(len(group.Pending)))
+		for _, pe := range group.Pending {
+			rw.write(encodeRawStreamID(pe.ID))
+			writeMillisecondTime(rw, pe.DeliveryTime)
+			rw.write(encodeLength64(pe.DeliveryCount))
+		}
+
+		rw.write(EncodeLength(len(group.Consumers)))
+		for _, c := range group.Consumers {
+			rw.writeString(c.Name)
+			writeMillisecondTime(rw, c.SeenTime)
+			writeMillisecondTime(rw, c.ActiveTime)
+
+			rw.write(EncodeLength(len(c.Pending)))
+			for _, id := range c.Pending {
+				rw.write(encodeRawStreamID(id))
+			}
+		}
+	}
+}
+
+// streamNodeElements lays out entries as the listpack elements of a stream
+// node, see decodeStreamNode.
+func streamNodeElements(master StreamID, entries []StreamEntry) []string {
+	itoa := func(n uint64) string {
+		return strconv.FormatUint(n, 10)
+	}
+
+	// master entry: count, deleted, no master fields, terminator
+	elements := []string{strconv.Itoa(len(entries)), "0", "0", "0"}
+	for _, e := range entries {
+		elements = append(elements,
+			"0", // flags
+			itoa(e.ID.Ms-master.Ms),
+			itoa(e.ID.Seq-master.Seq),
+			strconv.Itoa(len(e.Fields)/2),
+		)
+		elements = append(elements, e.Fields...)
+		elements = append(elements, strconv.Itoa(4+len(e.Fields)))
+	}
+
+	return elements
+}
+
+func writeStreamID(rw *rdbWriter, id StreamID) {
+	rw.write(encodeLength64(id.Ms))
+	rw.write(encodeLength64(id.Seq))
+}
+
+func encodeRawStreamID(id StreamID) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+	return b
+}
+
+func writeMillisecondTime(rw *rdbWriter, ms int64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(ms))
+	rw.write(b)
+}
+
+func writeModule(rw *rdbWriter, v ModuleValue) {
+	rw.write(encodeLength64(v.TypeID))
+	writeModuleValues(rw, v.Values)
+}
+
+func writeModuleValues(rw *rdbWriter, values []any) {
+	for _, value := range values {
+		switch value := value.(type) {
+		case int64:
+			rw.write(EncodeLength(rdbModuleOpcodeSInt))
+			rw.write(encodeLength64(uint64(value)))
+		case uint64:
+			rw.write(EncodeLength(rdbModuleOpcodeUInt))
+			rw.write(encodeLength64(value))
+		case float32:
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, math.Float32bits(value))
+			rw.write(EncodeLength(rdbModuleOpcodeFloat))
+			rw.write(b)
+		case float64:
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(value))
+			rw.write(EncodeLength(rdbModuleOpcodeDouble))
+			rw.write(b)
+		case string:
+			rw.write(EncodeLength(rdbModuleOpcodeString))
+			rw.writeString(value)
+		}
+	}
+	rw.write(EncodeLength(rdbModuleOpcodeEOF))
+}
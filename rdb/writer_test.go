@@ -0,0 +1,182 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ms = 1700000000000
+
+// sampleStreamEntries are the entries of both streams of stream.rdb, whose
+// first node has a deleted entry and entries with and without the master
+// fields.
+var sampleStreamEntries = []StreamEntry{
+	{ID: StreamID{ms, 0}, Fields: []string{"f1", "v1", "f2", "v2"}},
+	{ID: StreamID{ms + 1, 0}, Fields: []string{"other", "val"}},
+	{ID: StreamID{ms + 5, 3}, Fields: []string{"f1", "w1", "f2", "w2"}},
+	{ID: StreamID{ms + 10, 0}, Fields: []string{"a", "1", "b", "2"}},
+}
+
+var samplePending = []StreamPendingEntry{
+	{ID: StreamID{ms, 0}, DeliveryTime: ms + 100, DeliveryCount: 1},
+	{ID: StreamID{ms + 5, 3}, DeliveryTime: ms + 200, DeliveryCount: 3},
+}
+
+// sampleFiles maps the files of testdata, written the way redis does with
+// each of the encodings it used over time, to the keys they hold.
+var sampleFiles = map[string]map[string]any{
+	"ziplist.rdb": {
+		"list":   ListValue{"hello", "7", "-100", "1000", "-70000", "2147483653", "-1099511627776", strings.Repeat("x", 70)},
+		"zset":   ZSetValue{"a": 1, "b": 2.5, "c": -3},
+		"hash":   HashValue{"f1": "v1", "f2": "12", "f3": "300000"},
+		"zipmap": HashValue{"name": "redis", "kind": "zipmap"},
+	},
+	"listpack.rdb": {
+		"hash": HashValue{"f1": "v1", "f2": "100", "f3": "-1000", "f4": strings.Repeat("y", 100)},
+		"zset": ZSetValue{"a": 1, "b": 2.5, "c": 40000, "d": -8000000},
+		"set":  newSetValue([]string{"m1", "5", "8589934592", "-1099511627776", strings.Repeat("z", 4100)}),
+	},
+	"intset.rdb": {
+		"set16": newSetValue([]string{"1", "-2", "300", "32767"}),
+		"set32": newSetValue([]string{"70000", "-70000", "5"}),
+		"set64": newSetValue([]string{"1099511627776", "-1125899906842624", "0"}),
+	},
+	"quicklist.rdb": {
+		"list":  ListValue{"a", "b", "1", "2", "c", strings.Repeat("x", 70)},
+		"list2": ListValue{"a", "1", "-5000", strings.Repeat("p", 200), "z"},
+	},
+	"lzf.rdb": {
+		"compressed-key-" + strings.Repeat("k", 30): StringValue(strings.Repeat("the quick brown fox jumps over the lazy dog, ", 4)),
+		"repeat":   StringValue(strings.Repeat("ab", 500)),
+		"list":     ListValue{strings.Repeat("a", 100), strings.Repeat("0123456789", 10)},
+		"expiring": StringValue(strings.Repeat("z", 64)),
+	},
+	"stream.rdb": {
+		"stream1": &StreamValue{
+			Entries:      sampleStreamEntries,
+			Length:       4,
+			LastID:       StreamID{ms + 10, 0},
+			FirstID:      StreamID{ms, 0},
+			EntriesAdded: 4,
+			Groups: []StreamGroup{{
+				Name:        "g1",
+				LastID:      StreamID{ms + 5, 3},
+				EntriesRead: -1,
+				Pending:     samplePending,
+				Consumers: []StreamConsumer{
+					{Name: "alice", SeenTime: ms + 300, ActiveTime: -1, Pending: []StreamID{{ms, 0}}},
+					{Name: "bob", SeenTime: ms + 400, ActiveTime: -1, Pending: []StreamID{{ms + 5, 3}}},
+				},
+			}},
+		},
+		"stream3": &StreamValue{
+			Entries:      sampleStreamEntries,
+			Length:       4,
+			LastID:       StreamID{ms + 10, 0},
+			FirstID:      StreamID{ms, 0},
+			MaxDeletedID: StreamID{ms, 1},
+			EntriesAdded: 5,
+			Groups: []StreamGroup{
+				{
+					Name:        "g1",
+					LastID:      StreamID{ms + 5, 3},
+					EntriesRead: 3,
+					Pending:     samplePending,
+					Consumers: []StreamConsumer{
+						{Name: "alice", SeenTime: ms + 300, ActiveTime: ms + 250, Pending: []StreamID{{ms, 0}}},
+						{Name: "bob", SeenTime: ms + 400, ActiveTime: ms + 350, Pending: []StreamID{{ms + 5, 3}}},
+					},
+				},
+				{Name: "g2", EntriesRead: 0},
+			},
+		},
+	},
+}
+
+// checkSample compares the keys of the first database of data to want.
+func checkSample(t *testing.T, data RDB, want map[string]any) {
+	t.Helper()
+
+	if len(data.Databases) == 0 {
+		t.Fatal("no database loaded")
+	}
+
+	db := data.Databases[0]
+	if len(db.Fields) != len(want) {
+		t.Errorf("got %d keys, want %d", len(db.Fields), len(want))
+	}
+
+	for key, value := range want {
+		f, ok := db.Fields[key]
+		if !ok {
+			t.Errorf("key %q is missing", key)
+			continue
+		}
+
+		if !reflect.DeepEqual(f.Value, value) {
+			t.Errorf("key %q: got %#v, want %#v", key, f.Value, value)
+		}
+	}
+}
+
+func TestSampleFilesRoundTrip(t *testing.T) {
+	for name, want := range sampleFiles {
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := Options{Compression: true, Checksum: true}
+			data, err := ParseFile(bufio.NewReader(bytes.NewReader(b)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSample(t, data, want)
+
+			var buf bytes.Buffer
+			if err := Write(&buf, opts, data); err != nil {
+				t.Fatal(err)
+			}
+
+			again, err := ParseFile(bufio.NewReader(&buf), opts)
+			if err != nil {
+				t.Fatalf("reading the file written back: %v", err)
+			}
+			checkSample(t, again, want)
+
+			if !reflect.DeepEqual(again.AuxField, data.AuxField) {
+				t.Errorf("got aux fields %v back, want %v", again.AuxField, data.AuxField)
+			}
+
+			for key, f := range data.Databases[0].Fields {
+				if got := again.Databases[0].Fields[key].ExpiredTime; !got.Equal(f.ExpiredTime) {
+					t.Errorf("key %q: got expiry %v back, want %v", key, got, f.ExpiredTime)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleExpiry(t *testing.T) {
+	f, err := os.Open("testdata/lzf.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := ParseFile(bufio.NewReader(f), Options{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.UnixMilli(4102444800000)
+	if got := data.Databases[0].Fields["expiring"].ExpiredTime; !got.Equal(want) {
+		t.Fatalf("got expiry %v, want %v", got, want)
+	}
+}
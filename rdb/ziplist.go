@@ -0,0 +1,211 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Ziplists, zipmaps and intsets are the compact encodings older RDB
+// versions store small aggregates with. They are only ever read: they are
+// stored in the RDB as a plain string holding the serialized structure.
+
+var errZiplistCorrupt = errors.New("corrupt ziplist")
+
+// decodeZiplist returns the entries of a ziplist, integers being formatted
+// as strings.
+//
+//	<zlbytes:u32> <zltail:u32> <zllen:u16> <entry>... <0xFF>
+//	entry: <prevlen: 1 byte, or 0xFE + u32> <encoding> <data>
+func decodeZiplist(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, errZiplistCorrupt
+	}
+
+	var entries []string
+	p := 10
+	for {
+		if p >= len(b) {
+			return nil, errZiplistCorrupt
+		}
+
+		if b[p] == 0xFF {
+			return entries, nil
+		}
+
+		// skip prevlen
+		if b[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+
+		if p >= len(b) {
+			return nil, errZiplistCorrupt
+		}
+
+		enc := b[p]
+		p++
+
+		need := func(n int) error {
+			if p+n > len(b) {
+				return errZiplistCorrupt
+			}
+			return nil
+		}
+
+		switch {
+		case enc>>6 == 0b00: // string, 6 bit length
+			n := int(enc & 0x3f)
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			entries = append(entries, string(b[p:p+n]))
+			p += n
+		case enc>>6 == 0b01: // string, 14 bit big endian length
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			n := int(enc&0x3f)<<8 | int(b[p])
+			p++
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			entries = append(entries, string(b[p:p+n]))
+			p += n
+		case enc == 0b10000000: // string, 32 bit big endian length
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			n := int(binary.BigEndian.Uint32(b[p:]))
+			p += 4
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			entries = append(entries, string(b[p:p+n]))
+			p += n
+		case enc == 0xC0: // int16
+			if err := need(2); err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b[p:])))))
+			p += 2
+		case enc == 0xD0: // int32
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b[p:])))))
+			p += 4
+		case enc == 0xE0: // int64
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.FormatInt(int64(binary.LittleEndian.Uint64(b[p:])), 10))
+			p += 8
+		case enc == 0xF0: // int24
+			if err := need(3); err != nil {
+				return nil, err
+			}
+			v := int32(uint32(b[p])<<8|uint32(b[p+1])<<16|uint32(b[p+2])<<24) >> 8
+			entries = append(entries, strconv.Itoa(int(v)))
+			p += 3
+		case enc == 0xFE: // int8
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.Itoa(int(int8(b[p]))))
+			p++
+		case enc >= 0xF1 && enc <= 0xFD: // 4 bit immediate, 1 to 13 meaning 0 to 12
+			entries = append(entries, strconv.Itoa(int(enc&0x0f)-1))
+		default:
+			return nil, errZiplistCorrupt
+		}
+	}
+}
+
+// decodeZipmap returns the field/value pairs of a zipmap, the hash
+// encoding used before ziplists.
+//
+//	<zmlen:u8> (<len> <field> <len> <free:u8> <value> <free bytes>)... <0xFF>
+//	len: 1 byte below 254, else 254 followed by a u32
+func decodeZipmap(b []byte) ([]string, error) {
+	errCorrupt := errors.New("corrupt zipmap")
+
+	readLen := func(p int) (int, int, error) {
+		if p >= len(b) {
+			return 0, p, errCorrupt
+		}
+
+		if b[p] < 254 {
+			return int(b[p]), p + 1, nil
+		}
+
+		if b[p] == 254 && p+5 <= len(b) {
+			return int(binary.LittleEndian.Uint32(b[p+1:])), p + 5, nil
+		}
+
+		return 0, p, errCorrupt
+	}
+
+	var pairs []string
+	p := 1
+	for {
+		if p >= len(b) {
+			return nil, errCorrupt
+		}
+
+		if b[p] == 0xFF {
+			return pairs, nil
+		}
+
+		n, next, err := readLen(p)
+		if err != nil || next+n > len(b) {
+			return nil, errCorrupt
+		}
+		field := string(b[next : next+n])
+		p = next + n
+
+		n, next, err = readLen(p)
+		if err != nil || next+1+n > len(b) {
+			return nil, errCorrupt
+		}
+		free := int(b[next])
+		value := string(b[next+1 : next+1+n])
+		p = next + 1 + n + free
+
+		pairs = append(pairs, field, value)
+	}
+}
+
+// decodeIntset returns the members of an intset.
+//
+//	<encoding:u32 (2, 4 or 8)> <length:u32> <little endian integers>
+func decodeIntset(b []byte) ([]string, error) {
+	errCorrupt := errors.New("corrupt intset")
+	if len(b) < 8 {
+		return nil, errCorrupt
+	}
+
+	width := int(binary.LittleEndian.Uint32(b))
+	length := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(b) != 8+width*length {
+		return nil, errCorrupt
+	}
+
+	members := make([]string, length)
+	for i := range members {
+		p := b[8+i*width:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		members[i] = strconv.FormatInt(v, 10)
+	}
+
+	return members, nil
+}
@@ -0,0 +1,412 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits bounds what a peer can make us allocate while parsing its
+// messages. Every check is skipped when its limit is zero.
+type Limits struct {
+	MaxBulkLen      int64 // proto-max-bulk-len
+	MaxMultiBulkLen int64 // proto-max-multibulk-len
+	MaxInlineLen    int64 // proto-max-inline-len, also bounds header lines
+	MaxQueryLen     int64 // client-query-buffer-limit, a whole command
+}
+
+// ProtocolError is a malformed or oversized message. Its message is what a
+// server reports back to the client after "ERR Protocol error: ".
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return string(e)
+}
+
+const (
+	ErrInvalidMultiBulkLen = ProtocolError("invalid multibulk length")
+	ErrInvalidBulkLen      = ProtocolError("invalid bulk length")
+	ErrTooBigInline        = ProtocolError("too big inline request")
+	ErrQueryBufferLimit    = ProtocolError("client query buffer limit exceeded")
+	ErrTooDeep             = ProtocolError("too deeply nested message")
+	ErrUnknownType         = ProtocolError("unknown message type")
+	ErrInvalidLineEnding   = ProtocolError("invalid line ending")
+	ErrInvalidBulkEnding   = ProtocolError("invalid bulk string ending")
+	ErrUnbalancedQuotes    = ProtocolError("unbalanced quotes in request")
+)
+
+// maxNesting bounds how deep arrays may nest in a message, so a peer can't
+// exhaust the stack with a long run of "*1\r\n".
+const maxNesting = 128
+
+const (
+	TypeArray        = "array"
+	TypeBulkString   = "bulkstring"
+	TypeSimpleString = "simplestring"
+	TypeError        = "error"
+	TypeInteger      = "integer"
+)
+
+// Message is a decoded RESP value. Content is a string for every type but
+// arrays, which hold a []Message. Null bulk strings and arrays have a nil
+// Content.
+type Message struct {
+	Type    string
+	Content any
+}
+
+// ParseMessage reads a single message from r, returning it with the number
+// of bytes consumed.
+func ParseMessage(r *bufio.Reader, limits Limits) (Message, int, error) {
+	return readMessage(r, limits, 0, 0)
+}
+
+// ParseRequest reads a request sent by a client: an array of bulk strings,
+// returned with the number of bytes consumed. Any other element is rejected
+// as soon as its type byte is read, the way redis does, so nothing nested
+// is ever parsed. An empty or null array yields no arguments, to be
+// skipped.
+func ParseRequest(r *bufio.Reader, limits Limits) ([]string, int, error) {
+	numBytesRead := 0
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, numBytesRead, err
+	}
+
+	numBytesRead++
+
+	if b != '*' {
+		return nil, numBytesRead, ProtocolError(fmt.Sprintf("expected '*', got '%c'", b))
+	}
+
+	length, n, err := readLength(r, limits, ErrInvalidMultiBulkLen)
+	numBytesRead += n
+	if err != nil {
+		return nil, numBytesRead, err
+	}
+
+	if limits.MaxMultiBulkLen > 0 && int64(length) > limits.MaxMultiBulkLen {
+		return nil, numBytesRead, ErrInvalidMultiBulkLen
+	}
+
+	if length <= 0 {
+		return nil, numBytesRead, nil
+	}
+
+	// every bulk string takes at least 6 bytes ("$0\r\n\r\n"), reject
+	// counts that can't possibly fit before allocating for them.
+	if exceedsQueryLimit(limits, numBytesRead+length*6) {
+		return nil, numBytesRead, ErrQueryBufferLimit
+	}
+
+	args := make([]string, 0, minInt(length, 1024))
+	for i := 0; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, numBytesRead, err
+		}
+
+		numBytesRead++
+
+		if b != '$' {
+			return nil, numBytesRead, ProtocolError(fmt.Sprintf("expected '$', got '%c'", b))
+		}
+
+		arg, n, err := readBulk(r, limits, numBytesRead)
+		numBytesRead += n
+		if err != nil {
+			return nil, numBytesRead, err
+		}
+
+		if arg == nil {
+			return nil, numBytesRead, ErrInvalidBulkLen
+		}
+
+		args = append(args, *arg)
+	}
+
+	return args, numBytesRead, nil
+}
+
+// ParseInline reads a request sent as a plain space separated line, the
+// way telnet users talk to redis, returned with the number of bytes
+// consumed. Single and double quoted arguments may hold spaces, escapes
+// being honoured within double quotes only. Empty lines are skipped.
+func ParseInline(r *bufio.Reader, limits Limits) ([]string, int, error) {
+	numBytesRead := 0
+	for {
+		line, n, err := ReadLine(r, limits.MaxInlineLen)
+		numBytesRead += n
+		if err != nil {
+			return nil, numBytesRead, err
+		}
+
+		args, err := splitInlineArgs(string(bytes.TrimSuffix(line, []byte("\r"))))
+		if err != nil {
+			return nil, numBytesRead, err
+		}
+
+		if len(args) > 0 {
+			return args, numBytesRead, nil
+		}
+	}
+}
+
+// splitInlineArgs splits an inline request on spaces, honouring single and
+// double quoted arguments.
+func splitInlineArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		sb      strings.Builder
+		inArg   bool
+		quote   byte
+		escaped bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escaped:
+			sb.WriteByte(c)
+			escaped = false
+		case quote != 0 && c == '\\' && quote == '"':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			sb.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			sb.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, ErrUnbalancedQuotes
+	}
+
+	if inArg {
+		args = append(args, sb.String())
+	}
+
+	return args, nil
+}
+
+// readLength reads the length line following an array or bulk string type
+// byte, a malformed one failing with invalid.
+func readLength(r *bufio.Reader, limits Limits, invalid ProtocolError) (int, int, error) {
+	lengthStr, n, err := ReadUntilCRLF(r, limits.MaxInlineLen)
+	if err != nil {
+		return -1, n, err
+	}
+
+	length, err := strconv.Atoi(string(lengthStr))
+	if err != nil {
+		return -1, n, invalid
+	}
+
+	return length, n, nil
+}
+
+// exceedsQueryLimit reports whether a command of n bytes goes over
+// limits.MaxQueryLen.
+func exceedsQueryLimit(limits Limits, n int) bool {
+	return limits.MaxQueryLen > 0 && int64(n) > limits.MaxQueryLen
+}
+
+// readBulk reads a bulk string past its type byte, nil for a null one.
+// consumed is the number of bytes of the enclosing command read so far.
+func readBulk(r *bufio.Reader, limits Limits, consumed int) (*string, int, error) {
+	length, n, err := readLength(r, limits, ErrInvalidBulkLen)
+	if err != nil {
+		return nil, n, err
+	}
+
+	if length == -1 {
+		return nil, n, nil
+	}
+
+	if length < 0 || (limits.MaxBulkLen > 0 && int64(length) > limits.MaxBulkLen) {
+		return nil, n, ErrInvalidBulkLen
+	}
+
+	if exceedsQueryLimit(limits, consumed+n+length+2) {
+		return nil, n, ErrQueryBufferLimit
+	}
+
+	data, m, err := readBulkString(r, length)
+	if err != nil {
+		return nil, n + m, fmt.Errorf("failed to read bulkstring: %w", err)
+	}
+
+	return &data, n + m, nil
+}
+
+// readMessage parses a single message nested depth arrays deep. consumed
+// is the number of bytes of the enclosing command read so far, used to
+// enforce limits.MaxQueryLen before anything is allocated for the message.
+func readMessage(r *bufio.Reader, limits Limits, consumed, depth int) (Message, int, error) {
+	numBytesRead := 0
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return Message{}, numBytesRead, err
+	}
+
+	numBytesRead++
+
+	switch b {
+	case '*': // array
+		if depth >= maxNesting {
+			return Message{}, numBytesRead, ErrTooDeep
+		}
+
+		length, n, err := readLength(r, limits, ErrInvalidMultiBulkLen)
+		numBytesRead += n
+		if err != nil {
+			return Message{}, numBytesRead, err
+		}
+
+		if length < -1 || (limits.MaxMultiBulkLen > 0 && int64(length) > limits.MaxMultiBulkLen) {
+			return Message{}, numBytesRead, ErrInvalidMultiBulkLen
+		}
+
+		// every element takes at least 4 bytes ("+\r\n" or so), reject
+		// counts that can't possibly fit before allocating for them.
+		if exceedsQueryLimit(limits, consumed+numBytesRead+length*4) {
+			return Message{}, numBytesRead, ErrQueryBufferLimit
+		}
+
+		if length == -1 {
+			return Message{Type: "array"}, numBytesRead, nil
+		}
+
+		arr := make([]Message, 0, minInt(length, 1024))
+
+		for i := 0; i < length; i++ {
+			msg, n, err := readMessage(r, limits, consumed+numBytesRead, depth+1)
+			numBytesRead += n
+			if err != nil {
+				return Message{}, numBytesRead, fmt.Errorf("failed to parse array element: %w", err)
+			}
+
+			arr = append(arr, msg)
+		}
+
+		return Message{
+			Type:    "array",
+			Content: arr,
+		}, numBytesRead, nil
+	case '$': // bulk string
+		data, n, err := readBulk(r, limits, consumed+numBytesRead)
+		numBytesRead += n
+		if err != nil {
+			return Message{}, numBytesRead, err
+		}
+
+		if data == nil {
+			return Message{Type: "bulkstring"}, numBytesRead, nil
+		}
+
+		return Message{
+			Type:    "bulkstring",
+			Content: *data,
+		}, numBytesRead, nil
+	case '+', '-', ':': // simple string, error, integer
+		line, n, err := ReadUntilCRLF(r, limits.MaxInlineLen)
+		numBytesRead += n
+		if err != nil {
+			return Message{}, numBytesRead, err
+		}
+
+		msgType := map[byte]string{'+': "simplestring", '-': "error", ':': "integer"}[b]
+		return Message{
+			Type:    msgType,
+			Content: string(line),
+		}, numBytesRead, nil
+	}
+
+	return Message{}, numBytesRead, ErrUnknownType
+}
+
+// ReadUntilCRLF reads a CRLF terminated line and returns it without the
+// terminator.
+func ReadUntilCRLF(r *bufio.Reader, max int64) ([]byte, int, error) {
+	line, n, err := ReadLine(r, max)
+	if err != nil {
+		return nil, n, err
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r")) {
+		return nil, n, ErrInvalidLineEnding
+	}
+
+	return line[:len(line)-1], n, nil // remove the CR
+}
+
+// ReadLine reads up to and excluding the next '\n', failing as soon as the
+// line gets longer than max bytes (when max > 0) so a peer can't make us
+// buffer an endless line.
+func ReadLine(r *bufio.Reader, max int64) ([]byte, int, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if max > 0 && int64(len(line)) > max {
+			return nil, len(line), ErrTooBigInline
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err != nil {
+			return nil, len(line), err
+		}
+
+		return line[:len(line)-1], len(line), nil
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func readBulkString(r *bufio.Reader, length int) (string, int, error) {
+	// the buffer grows with the data actually received rather than with the
+	// announced length.
+	var sb strings.Builder
+	n, err := io.CopyN(&sb, r, int64(length))
+	if err != nil {
+		return "", int(n), err
+	}
+
+	crlf := make([]byte, 2)
+	m, err := io.ReadFull(r, crlf)
+	if err != nil {
+		return "", int(n) + m, err
+	}
+
+	if string(crlf) != "\r\n" {
+		return "", int(n) + m, ErrInvalidBulkEnding
+	}
+
+	return sb.String(), int(n) + m, nil
+}
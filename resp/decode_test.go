@@ -0,0 +1,186 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		args   []string
+		err    error
+	}{
+		{name: "valid", input: "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", args: []string{"GET", "k"}},
+		{name: "empty bulk string", input: "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", args: []string{"ECHO", ""}},
+		{name: "empty array", input: "*0\r\n"},
+		{name: "null array", input: "*-1\r\n"},
+		{name: "nested array", input: strings.Repeat("*1\r\n", 1000), err: ProtocolError("expected '$', got '*'")},
+		{name: "simple string element", input: "*1\r\n+PING\r\n", err: ProtocolError("expected '$', got '+'")},
+		{name: "not an array", input: "$4\r\nPING\r\n", err: ProtocolError("expected '*', got '$'")},
+		{name: "invalid multibulk length", input: "*abc\r\n", err: ErrInvalidMultiBulkLen},
+		{name: "invalid bulk length", input: "*1\r\n$abc\r\n", err: ErrInvalidBulkLen},
+		{name: "null bulk string", input: "*1\r\n$-1\r\n", err: ErrInvalidBulkLen},
+		{name: "negative bulk length", input: "*1\r\n$-2\r\n", err: ErrInvalidBulkLen},
+		{
+			name:   "multibulk length over limit",
+			input:  "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n",
+			limits: Limits{MaxMultiBulkLen: 2},
+			err:    ErrInvalidMultiBulkLen,
+		},
+		{
+			name:   "bulk length over limit",
+			input:  "*1\r\n$5\r\nhello\r\n",
+			limits: Limits{MaxBulkLen: 4},
+			err:    ErrInvalidBulkLen,
+		},
+		{
+			name:   "huge multibulk length",
+			input:  "*1000000000\r\n",
+			limits: Limits{MaxQueryLen: 1024},
+			err:    ErrQueryBufferLimit,
+		},
+		{
+			name:   "huge bulk length",
+			input:  "*1\r\n$1000000000\r\n",
+			limits: Limits{MaxQueryLen: 1024},
+			err:    ErrQueryBufferLimit,
+		},
+		{name: "missing CR", input: "*1\n$4\r\nPING\r\n", err: ErrInvalidLineEnding},
+		{name: "invalid bulk string ending", input: "*1\r\n$4\r\nPINGxx", err: ErrInvalidBulkEnding},
+		{
+			name:   "endless length line",
+			input:  "*" + strings.Repeat("1", 100),
+			limits: Limits{MaxInlineLen: 64},
+			err:    ErrTooBigInline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, _, err := ParseRequest(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if tt.err == nil && !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("got %q, want %q", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseRequestConsumed(t *testing.T) {
+	input := "*1\r\n$4\r\nPING\r\n*0\r\n"
+	r := bufio.NewReader(strings.NewReader(input))
+
+	_, n, err := ParseRequest(r, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 14 {
+		t.Fatalf("got %d bytes consumed, want 14", n)
+	}
+
+	args, n, err := ParseRequest(r, Limits{})
+	if err != nil || args != nil || n != 4 {
+		t.Fatalf("got %q, %d, %v for the empty array", args, n, err)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		args   []string
+		err    error
+	}{
+		{name: "valid", input: "SET k v\r\n", args: []string{"SET", "k", "v"}},
+		{name: "bare LF", input: "PING\n", args: []string{"PING"}},
+		{name: "extra spaces and tabs", input: "  GET\t k  \r\n", args: []string{"GET", "k"}},
+		{name: "empty lines skipped", input: "\r\n  \r\n\nPING\r\n", args: []string{"PING"}},
+		{name: "double quotes", input: "SET k \"hello world\"\r\n", args: []string{"SET", "k", "hello world"}},
+		{name: "single quotes", input: "SET 'my key' ''\r\n", args: []string{"SET", "my key", ""}},
+		{name: "escape in double quotes", input: "ECHO \"a\\\"b\"\r\n", args: []string{"ECHO", "a\"b"}},
+		{name: "no escape in single quotes", input: "ECHO 'a\\b'\r\n", args: []string{"ECHO", "a\\b"}},
+		{name: "quotes within an argument", input: "ECHO a\"b c\"d\r\n", args: []string{"ECHO", "ab cd"}},
+		{name: "unbalanced double quote", input: "SET k \"v\r\n", err: ErrUnbalancedQuotes},
+		{name: "unbalanced single quote", input: "SET 'k v\r\n", err: ErrUnbalancedQuotes},
+		{name: "at the limit", input: "PING\r\n", limits: Limits{MaxInlineLen: 6}, args: []string{"PING"}},
+		{name: "over the limit", input: "ECHO " + strings.Repeat("a", 100) + "\r\n", limits: Limits{MaxInlineLen: 64}, err: ErrTooBigInline},
+		{name: "endless line", input: strings.Repeat("a", 100), limits: Limits{MaxInlineLen: 64}, err: ErrTooBigInline},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, _, err := ParseInline(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if tt.err == nil && !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("got %q, want %q", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseInlineConsumed(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\r\nPING\r\nECHO a\n"))
+
+	_, n, err := ParseInline(r, Limits{})
+	if err != nil || n != 8 {
+		t.Fatalf("got %d bytes consumed, %v, want 8", n, err)
+	}
+
+	args, n, err := ParseInline(r, Limits{})
+	if err != nil || n != 7 || !reflect.DeepEqual(args, []string{"ECHO", "a"}) {
+		t.Fatalf("got %q, %d, %v for the second line", args, n, err)
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		msg   Message
+		err   error
+	}{
+		{
+			name:  "nested array",
+			input: "*2\r\n*1\r\n:1\r\n$-1\r\n",
+			msg: Message{Type: TypeArray, Content: []Message{
+				{Type: TypeArray, Content: []Message{{Type: TypeInteger, Content: "1"}}},
+				{Type: TypeBulkString},
+			}},
+		},
+		{name: "simple string", input: "+OK\r\n", msg: Message{Type: TypeSimpleString, Content: "OK"}},
+		{name: "error", input: "-ERR no\r\n", msg: Message{Type: TypeError, Content: "ERR no"}},
+		{name: "too deeply nested", input: strings.Repeat("*1\r\n", 20000), err: ErrTooDeep},
+		{name: "unknown type", input: "?\r\n", err: ErrUnknownType},
+		{name: "invalid multibulk length", input: "*abc\r\n", err: ErrInvalidMultiBulkLen},
+		{name: "invalid bulk length", input: "$abc\r\n", err: ErrInvalidBulkLen},
+		{name: "negative bulk length", input: "$-5\r\n", err: ErrInvalidBulkLen},
+		{name: "missing CR", input: "+OK\n", err: ErrInvalidLineEnding},
+		{name: "invalid bulk string ending", input: "$2\r\nOK\n\n", err: ErrInvalidBulkEnding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, _, err := ParseMessage(bufio.NewReader(strings.NewReader(tt.input)), Limits{})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if tt.err == nil && !reflect.DeepEqual(msg, tt.msg) {
+				t.Fatalf("got %#v, want %#v", msg, tt.msg)
+			}
+		})
+	}
+}
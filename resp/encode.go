@@ -0,0 +1,49 @@
+package resp
+
+import (
+	"fmt"
+	"strings"
+)
+
+func EncodeBulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func EncodeBulkStrings(ss ...string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(ss)))
+	for _, s := range ss {
+		sb.WriteString(EncodeBulkString(s))
+	}
+
+	return sb.String()
+}
+
+// EncodeError encodes msg as a RESP error. msg is expected to start with an
+// error code such as "ERR".
+func EncodeError(msg string) string {
+	return fmt.Sprintf("-%s\r\n", msg)
+}
+
+func EncodeSimpleString(s string) string {
+	return fmt.Sprintf("+%s\r\n", s)
+}
+
+func EncodeInteger(i int) string {
+	return fmt.Sprintf(":%d\r\n", i)
+}
+
+// EncodeArray wraps the already encoded items into a RESP array.
+func EncodeArray(items ...string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(items)))
+	for _, item := range items {
+		sb.WriteString(item)
+	}
+
+	return sb.String()
+}
+
+func EncodeNullArray() string {
+	return "*-1\r\n"
+}